@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// maxBatchRequests is the maximum number of sub-requests in a POST /v1/batch request.
+const maxBatchRequests = 50
+
+// batchMethods are the methods a sub-request may use.
+var batchMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// errBatchFailed is returned inside the transaction of an atomic batch when one of the
+// sub-requests failed, to roll it back.
+var errBatchFailed = errors.New("a request of the batch failed")
+
+// batchRequest is a sub-request of a POST /v1/batch request. The body is sent as-is,
+// with a Content-Type of application/json unless the headers say otherwise.
+type batchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// batchResult is the response to a sub-request. A body which isn't JSON is sent as a
+// JSON string.
+type batchResult struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// The batchHandler() handles POST /v1/batch, which lets clients send several requests
+// in one round trip. The body is an array of sub-requests, such as
+//
+//	[{"method": "POST", "path": "/v1/movies", "body": {"title": "Moana", ...}},
+//	 {"method": "GET", "path": "/v1/movies?genres=animation"}]
+//
+// which are dispatched in order through the router, as if they had been sent on their
+// own, and the response holds the result of each in a "results" array.
+//
+// With ?atomic=true the sub-requests run in a single transaction, which is only
+// committed if they all succeed (with a status below 400). The batch stops at the first
+// failure, the sub-requests after it get a 424 Failed Dependency result, and the
+// response's "committed" field is false.
+//
+// A batch may carry an Idempotency-Key header, like the create endpoints (see
+// idempotent()), so that retrying it doesn't create its records twice.
+func (app *application) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var requests []batchRequest
+	err := app.readJSON(w, r, &requests)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	atomic := false
+	errs := validateBatch(requests)
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		atomic, err = strconv.ParseBool(v)
+		if err != nil {
+			errs["atomic"] = "must be a boolean"
+		}
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	if !atomic {
+		results := app.dispatchBatch(r, requests, false)
+		err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var results []batchResult
+	err = app.modelsFor(r).WithTx(r.Context(), func(tx data.Models) error {
+		// The handlers of the sub-requests use the models bound to the transaction.
+		results = app.dispatchBatch(app.contextSetModels(r, tx), requests, true)
+		for _, result := range results {
+			if result.Status >= http.StatusBadRequest {
+				return errBatchFailed
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results, "committed": err == nil}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateBatch checks the sub-requests of a batch, and returns a map of the problems
+// found, keyed like "requests[0].method".
+func validateBatch(requests []batchRequest) map[string]string {
+	errs := make(map[string]string)
+	switch {
+	case len(requests) == 0:
+		errs["requests"] = "must not be empty"
+	case len(requests) > maxBatchRequests:
+		errs["requests"] = fmt.Sprintf("must not contain more than %d requests", maxBatchRequests)
+	}
+
+	for i, br := range requests {
+		key := fmt.Sprintf("requests[%d]", i)
+		if !contains(batchMethods, br.Method) {
+			errs[key+".method"] = "must be one of " + strings.Join(batchMethods, ", ")
+		}
+		u, err := url.ParseRequestURI(br.Path)
+		switch {
+		case err != nil || u.Host != "" || !strings.HasPrefix(u.Path, "/v1/"):
+			errs[key+".path"] = "must be an API path, such as /v1/movies"
+		case u.Path == "/v1/batch":
+			errs[key+".path"] = "must not be a batch"
+		}
+	}
+	return errs
+}
+
+// dispatchBatch sends each sub-request through the router, in order, and returns their
+// results. If stopOnFailure is true, the sub-requests after the first which fails
+// aren't sent.
+func (app *application) dispatchBatch(r *http.Request, requests []batchRequest, stopOnFailure bool) []batchResult {
+	// The router is built on the first batch, and shared by all the later ones.
+	app.batchRouterOnce.Do(func() {
+		app.batchRouter = app.router()
+	})
+
+	results := make([]batchResult, len(requests))
+	failed := false
+	for i, br := range requests {
+		if failed && stopOnFailure {
+			results[i] = batchResult{
+				Status: http.StatusFailedDependency,
+				Body:   json.RawMessage(`{"error":"the request wasn't sent, as an earlier request of the batch failed"}`),
+			}
+			continue
+		}
+
+		// The sub-requests share the context of the batch, and so its request ID.
+		// validateBatch() has ruled out the methods and paths NewRequest rejects.
+		req, err := http.NewRequestWithContext(r.Context(), br.Method, br.Path, bytes.NewReader(br.Body))
+		if err != nil {
+			panic(err)
+		}
+		for key, value := range br.Headers {
+			req.Header.Set(key, value)
+		}
+		if len(br.Body) > 0 && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.RemoteAddr = r.RemoteAddr
+
+		results[i] = app.serveBatchRequest(req)
+		if results[i].Status >= http.StatusBadRequest {
+			failed = true
+		}
+	}
+	return results
+}
+
+// serveBatchRequest sends a sub-request through the router and returns its result. A
+// handler which panics (e.g. an export failing halfway through) only fails its own
+// sub-request: what it wrote is replaced by a 500 Internal Server Error response, and
+// the rest of the batch carries on.
+func (app *application) serveBatchRequest(req *http.Request) (result batchResult) {
+	defer func() {
+		if p := recover(); p != nil {
+			bw := &batchResponseWriter{header: make(http.Header)}
+			app.serverErrorResponse(bw, req, fmt.Errorf("%v", p))
+			result = bw.result()
+		}
+	}()
+
+	bw := &batchResponseWriter{header: make(http.Header)}
+	app.batchRouter.ServeHTTP(bw, req)
+	return bw.result()
+}
+
+// batchResponseWriter is the http.ResponseWriter of a sub-request, which keeps the
+// response in memory.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *batchResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *batchResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *batchResponseWriter) Write(b []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	return bw.body.Write(b)
+}
+
+// Flush is a no-op, so that the handlers streaming their response (e.g. the export)
+// work in a batch too.
+func (bw *batchResponseWriter) Flush() {}
+
+// result returns the response written to bw.
+func (bw *batchResponseWriter) result() batchResult {
+	result := batchResult{Status: bw.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+
+	if len(bw.header) > 0 {
+		result.Headers = make(map[string]string, len(bw.header))
+		for key, values := range bw.header {
+			result.Headers[key] = strings.Join(values, ", ")
+		}
+	}
+
+	body := bw.body.Bytes()
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		result.Body = bytes.TrimSpace(body)
+	default:
+		result.Body, _ = json.Marshal(string(body))
+	}
+	return result
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestBatch(t *testing.T) {
+	const moana = `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`
+
+	tests := []struct {
+		name          string
+		query         string
+		body          string
+		wantCode      int
+		wantStatuses  []int
+		wantCommitted any
+		wantMovie     bool
+	}{
+		{
+			name: "Independent requests",
+			body: `[
+				{"method": "POST", "path": "/v1/movies", "body": ` + moana + `},
+				{"method": "GET", "path": "/v1/movies/1"},
+				{"method": "GET", "path": "/v1/movies/42"},
+				{"method": "PATCH", "path": "/v1/movies/1", "body": {"runtime": 108}}
+			]`,
+			wantCode:     http.StatusOK,
+			wantStatuses: []int{http.StatusCreated, http.StatusOK, http.StatusNotFound, http.StatusOK},
+			wantMovie:    true,
+		},
+		{
+			name:  "Atomic",
+			query: "?atomic=true",
+			body: `[
+				{"method": "POST", "path": "/v1/movies", "body": ` + moana + `},
+				{"method": "PATCH", "path": "/v1/movies/1", "body": {"runtime": 108}}
+			]`,
+			wantCode:      http.StatusOK,
+			wantStatuses:  []int{http.StatusCreated, http.StatusOK},
+			wantCommitted: true,
+			wantMovie:     true,
+		},
+		{
+			name:  "Atomic rollback",
+			query: "?atomic=true",
+			body: `[
+				{"method": "POST", "path": "/v1/movies", "body": ` + moana + `},
+				{"method": "PATCH", "path": "/v1/movies/1", "body": {"year": 1000}},
+				{"method": "GET", "path": "/v1/movies/1"}
+			]`,
+			wantCode:      http.StatusOK,
+			wantStatuses:  []int{http.StatusCreated, http.StatusUnprocessableEntity, http.StatusFailedDependency},
+			wantCommitted: false,
+		},
+		{
+			name: "Panicking request",
+			body: `[
+				{"method": "POST", "path": "/v1/movies", "body": ` + moana + `},
+				{"method": "GET", "path": "/v1/movies?sort=unsafe"},
+				{"method": "GET", "path": "/v1/movies/1"}
+			]`,
+			wantCode:     http.StatusOK,
+			wantStatuses: []int{http.StatusCreated, http.StatusInternalServerError, http.StatusOK},
+			wantMovie:    true,
+		},
+		{
+			name:  "Atomic panicking request",
+			query: "?atomic=true",
+			body: `[
+				{"method": "POST", "path": "/v1/movies", "body": ` + moana + `},
+				{"method": "GET", "path": "/v1/movies?sort=unsafe"},
+				{"method": "GET", "path": "/v1/movies/1"}
+			]`,
+			wantCode:      http.StatusOK,
+			wantStatuses:  []int{http.StatusCreated, http.StatusInternalServerError, http.StatusFailedDependency},
+			wantCommitted: false,
+		},
+		{
+			name:     "Nested batch",
+			body:     `[{"method": "POST", "path": "/v1/batch", "body": []}]`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Invalid method and path",
+			body:     `[{"method": "TRACE", "path": "http://example.com/v1/movies"}]`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Empty batch",
+			body:     `[]`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Invalid atomic",
+			query:    "?atomic=maybe",
+			body:     `[{"method": "GET", "path": "/v1/movies"}]`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+
+			code, _, body := ts.do(t, http.MethodPost, "/v1/batch"+tt.query, tt.body, nil)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var rs struct {
+				Results   []batchResult `json:"results"`
+				Committed any           `json:"committed"`
+			}
+			decode(t, body, &rs)
+			if len(rs.Results) != len(tt.wantStatuses) {
+				t.Fatalf("got %d results; want %d", len(rs.Results), len(tt.wantStatuses))
+			}
+			for i, result := range rs.Results {
+				if result.Status != tt.wantStatuses[i] {
+					t.Errorf("result %d: got status %d; want %d (body %s)", i, result.Status, tt.wantStatuses[i], result.Body)
+				}
+			}
+			if rs.Committed != tt.wantCommitted {
+				t.Errorf("got committed %v; want %v", rs.Committed, tt.wantCommitted)
+			}
+
+			_, err := app.models.Movies.Get(context.Background(), 1)
+			if tt.wantMovie && err != nil {
+				t.Errorf("got error %v for the created movie", err)
+			}
+			if !tt.wantMovie && !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("got error %v for the rolled back movie; want %v", err, data.ErrRecordNotFound)
+			}
+		})
+	}
+}
+
+func TestBatchResultBody(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	code, _, body := ts.do(t, http.MethodPost, "/v1/batch", `[
+		{"method": "GET", "path": "/v1/movies/1"},
+		{"method": "GET", "path": "/v1/movies?format=csv", "headers": {"Accept": "text/csv"}}
+	]`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+
+	var rs struct {
+		Results []struct {
+			Status  int               `json:"status"`
+			Headers map[string]string `json:"headers"`
+			Body    any               `json:"body"`
+		} `json:"results"`
+	}
+	decode(t, body, &rs)
+
+	movie, ok := rs.Results[0].Body.(map[string]any)["movie"].(map[string]any)
+	if !ok || movie["title"] != "Moana" {
+		t.Errorf("got body %v; want the movie", rs.Results[0].Body)
+	}
+	if got := rs.Results[0].Headers["Etag"]; got != `"1-1"` {
+		t.Errorf("got ETag %q; want %q", got, `"1-1"`)
+	}
+	if csv, ok := rs.Results[1].Body.(string); !ok || csv != "id,title,year,runtime,genres,version\n1,Moana,2016,107,animation,1\n" {
+		t.Errorf("got body %q; want the CSV list as a string", rs.Results[1].Body)
+	}
+}
+
+func TestBatchIdempotencyKeys(t *testing.T) {
+	const moana = `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	create := func(key string) (int, http.Header) {
+		code, headers, _ := ts.do(t, http.MethodPost, "/v1/movies", moana,
+			http.Header{"Content-Type": {"application/json"}, "Idempotency-Key": {key}})
+		return code, headers
+	}
+
+	// The key of a request in a rolled back batch is rolled back along with the
+	// movie, so a retry outside the batch creates the movie.
+	code, _, body := ts.do(t, http.MethodPost, "/v1/batch?atomic=true", `[
+		{"method": "POST", "path": "/v1/movies", "headers": {"Idempotency-Key": "rolled-back"}, "body": `+moana+`},
+		{"method": "PATCH", "path": "/v1/movies/1", "body": {"year": 1000}}
+	]`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+	}
+	code, headers := create("rolled-back")
+	if code != http.StatusCreated || headers.Get("Idempotent-Replayed") != "" {
+		t.Errorf("rolled back key: got status %d and Idempotent-Replayed %q; want %d and none",
+			code, headers.Get("Idempotent-Replayed"), http.StatusCreated)
+	}
+
+	// The key of a request in a committed batch is kept.
+	code, _, body = ts.do(t, http.MethodPost, "/v1/batch?atomic=true", `[
+		{"method": "POST", "path": "/v1/movies", "headers": {"Idempotency-Key": "committed"}, "body": `+moana+`}
+	]`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+	}
+	code, headers = create("committed")
+	if code != http.StatusCreated || headers.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("committed key: got status %d and Idempotent-Replayed %q; want %d and %q",
+			code, headers.Get("Idempotent-Replayed"), http.StatusCreated, "true")
+	}
+	if _, err := app.models.Movies.Get(context.Background(), 3); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got error %v for a third movie; want %v", err, data.ErrRecordNotFound)
+	}
+}
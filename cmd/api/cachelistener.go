@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+var (
+	// cacheNotifications counts the cache invalidation notifications received, by
+	// result ("evicted" or "invalid").
+	cacheNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "greenlight",
+		Subsystem: "cache",
+		Name:      "notifications_total",
+		Help:      "Number of cache invalidation notifications received by result.",
+	}, []string{"result"})
+
+	// cacheListenerConnected is 1 while the notification listener is connected to
+	// PostgreSQL, 0 otherwise.
+	cacheListenerConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "greenlight",
+		Subsystem: "cache",
+		Name:      "listener_connected",
+		Help:      "Whether the cache invalidation listener is connected.",
+	})
+
+	// cacheListenerReconnects counts the times the listener lost its connection and
+	// established a new one.
+	cacheListenerReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "greenlight",
+		Subsystem: "cache",
+		Name:      "listener_reconnects_total",
+		Help:      "Number of times the cache invalidation listener reconnected.",
+	})
+)
+
+// The listenForInvalidations() method evicts from the cache the movies and actors that
+// the database reports as updated or deleted (see data.CacheInvalidationChannel), so
+// that a write handled by another instance of the API doesn't leave this one serving
+// stale records. It blocks until ctx is done.
+//
+// The pq.Listener reconnects on its own when the connection is lost. Any notification
+// sent while it was disconnected is lost, so the whole cache is purged on reconnection.
+func (app *application) listenForInvalidations(ctx context.Context, cache *data.Cache) {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected:
+			cacheListenerConnected.Set(1)
+			app.logger.PrintInfo("cache invalidation listener connected", nil)
+		case pq.ListenerEventReconnected:
+			cache.Purge()
+			cacheListenerConnected.Set(1)
+			cacheListenerReconnects.Inc()
+			app.logger.PrintInfo("cache invalidation listener reconnected, cache purged", nil)
+		case pq.ListenerEventDisconnected:
+			cacheListenerConnected.Set(0)
+			app.logger.PrintError(err, map[string]string{"listener": "cache invalidation"})
+		case pq.ListenerEventConnectionAttemptFailed:
+			app.logger.PrintError(err, map[string]string{"listener": "cache invalidation"})
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(data.CacheInvalidationChannel)
+	if err != nil {
+		// The channel is still registered, and will be listened on once the
+		// listener manages to connect.
+		app.logger.PrintError(err, map[string]string{"listener": "cache invalidation"})
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			// A nil notification is sent after a reconnection, which is handled in
+			// the event callback above.
+			if n == nil {
+				continue
+			}
+			err := cache.HandleNotification(n.Extra)
+			if err != nil {
+				cacheNotifications.WithLabelValues("invalid").Inc()
+				app.logger.PrintError(err, map[string]string{"listener": "cache invalidation"})
+				continue
+			}
+			cacheNotifications.WithLabelValues("evicted").Inc()
+		case <-time.After(90 * time.Second):
+			// Check the connection now and then, so that a dead one is noticed even
+			// when no notification is coming in.
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressMinSize is the smallest response body worth compressing. Below it, the
+// compression overhead outweighs the bytes saved.
+const compressMinSize = 1024
+
+// The content encodings the compress() middleware can apply, in order of preference.
+var compressEncodings = []string{"br", "gzip"}
+
+// incompressibleTypes lists the media types (or type/ prefixes) of content which is
+// compressed already, so that compressing it again would only waste CPU time.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-brotli",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+}
+
+// The compress() middleware compresses the response body with Brotli or gzip, whichever
+// the Accept-Encoding header of the request prefers. The body is buffered until it
+// reaches compressMinSize bytes, and sent as-is if it is smaller than that, if it has
+// a Content-Encoding already (i.e. the handler compressed it itself) or
+// if its Content-Type is in incompressibleTypes.
+//
+// A compressed response is a different representation from an uncompressed one, so a
+// strong ETag gets the encoding appended (e.g. "1-1-gzip"), as RFC 9110 requires. The
+// suffix is removed from the If-None-Match and If-Match headers of the request before
+// the handlers see them, so that their ETag comparisons keep working, and a 304 Not
+// Modified response gets back the ETag the client sent.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ifNoneMatch := r.Header.Get("If-None-Match")
+		for _, header := range []string{"If-None-Match", "If-Match"} {
+			if value := r.Header.Get(header); value != "" {
+				r.Header.Set(header, stripEncodingSuffixes(value))
+			}
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, method: r.Method, ifNoneMatch: ifNoneMatch}
+		defer func() {
+			err := cw.Close()
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{
+					"request_method": r.Method,
+					"request_url":    r.URL.String(),
+				})
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the encoding in compressEncodings which the Accept-Encoding
+// header value prefers, or "" if the response should be sent uncompressed.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, encoding := range compressEncodings {
+		// An explicit entry for the encoding takes precedence over the * wildcard.
+		q, explicit := 0.0, false
+		for _, entry := range strings.Split(acceptEncoding, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != encoding && (name != "*" || explicit) {
+				continue
+			}
+
+			entryQ := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				var err error
+				entryQ, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					entryQ = 0
+				}
+			}
+			q, explicit = entryQ, name == encoding
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// stripEncodingSuffixes removes the encoding suffixes added by compress() from the
+// ETags in an If-None-Match or If-Match header value.
+func stripEncodingSuffixes(value string) string {
+	for _, encoding := range compressEncodings {
+		value = strings.ReplaceAll(value, "-"+encoding+`"`, `"`)
+	}
+	return value
+}
+
+// compressResponseWriter wraps an http.ResponseWriter and compresses what is written
+// through it, once it knows whether the response is worth compressing.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	method      string
+	ifNoneMatch string // as sent by the client, with the encoding suffixes
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	enc         io.WriteCloser
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.status = status
+	cw.wroteHeader = true
+
+	// Informational and bodiless responses are sent straight away.
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.start(false)
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		return cw.writer().Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= compressMinSize {
+		err := cw.start(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush sends what has been written so far to the client. A streamed response is
+// compressed whatever the size of its first chunk, as its final size isn't known.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.start(true)
+	}
+	if flusher, ok := cw.enc.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close sends the rest of the response, and must be called once the handler returns.
+func (cw *compressResponseWriter) Close() error {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// The handler didn't write anything: let net/http send its default
+			// response.
+			return nil
+		}
+		return cw.start(len(cw.buf) >= compressMinSize)
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController
+// can reach optional interfaces such as the write deadlines.
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// start decides whether the response is compressed, sends the header, and then the
+// buffered start of the body.
+func (cw *compressResponseWriter) start(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	if etag := h.Get("ETag"); etag != "" && cw.status == http.StatusNotModified {
+		// A 304 response describes the representation the client has cached, which
+		// is only compressed if its body was large enough: the If-None-Match header
+		// tells which one it is.
+		h.Set("ETag", notModifiedETag(etag, cw.ifNoneMatch))
+	}
+
+	if compress && cw.method != http.MethodHead && compressible(h) {
+		// Let net/http sniff the Content-Type from the uncompressed body, as it would
+		// otherwise sniff it from the compressed one.
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", encodingETag(etag, cw.encoding))
+		}
+
+		switch cw.encoding {
+		case "br":
+			cw.enc = brotli.NewWriter(cw.ResponseWriter)
+		case "gzip":
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.writer().Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// writer returns where the body goes once start() has been called.
+func (cw *compressResponseWriter) writer() io.Writer {
+	if cw.enc != nil {
+		return cw.enc
+	}
+	return cw.ResponseWriter
+}
+
+// compressible reports whether a response with the header h may be compressed.
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodingETag returns the ETag of the representation of a response compressed with
+// encoding. Weak ETags are left alone, as they only promise equivalent content.
+func encodingETag(etag, encoding string) string {
+	if etag == "" || strings.HasPrefix(etag, "W/") || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// notModifiedETag returns the ETag of a 304 response: etag with the encoding suffix of
+// the matching ETag in the If-None-Match header value, if it has one.
+func notModifiedETag(etag, ifNoneMatch string) string {
+	for _, encoding := range compressEncodings {
+		if encoded := encodingETag(etag, encoding); encoded != etag && strings.Contains(ifNoneMatch, encoded) {
+			return encoded
+		}
+	}
+	return etag
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestCompress(t *testing.T) {
+	app := newTestApplication(t)
+	for i := 1; i <= 20; i++ {
+		seedMovies(t, app, &data.Movie{Title: fmt.Sprintf("Movie %d", i), Year: int32(2000 + i), Runtime: 90, Genres: []string{"drama"}})
+	}
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name           string
+		urlPath        string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"Gzip", "/v1/movies", "gzip", "gzip"},
+		{"Brotli preferred", "/v1/movies", "gzip, br", "br"},
+		{"Quality values", "/v1/movies", "br;q=0.5, gzip;q=0.8", "gzip"},
+		{"Wildcard", "/v1/movies", "*", "br"},
+		{"Refused encoding", "/v1/movies", "br;q=0, *", "gzip"},
+		{"Identity", "/v1/movies", "identity", ""},
+		{"Small body", "/v1/movies/1", "gzip, br", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.do(t, http.MethodGet, tt.urlPath, "", http.Header{"Accept-Encoding": {tt.acceptEncoding}})
+			if code != http.StatusOK {
+				t.Fatalf("got status %d; want %d", code, http.StatusOK)
+			}
+			if got := headers.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q; want %q", got, tt.wantEncoding)
+			}
+			if !strings.Contains(strings.Join(headers.Values("Vary"), ","), "Accept-Encoding") {
+				t.Errorf("got Vary %q; want it to list Accept-Encoding", headers.Values("Vary"))
+			}
+
+			var r io.Reader = bytes.NewReader(body)
+			switch tt.wantEncoding {
+			case "gzip":
+				gr, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gr
+			case "br":
+				r = brotli.NewReader(r)
+			}
+			plain, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rs map[string]any
+			decode(t, plain, &rs)
+		})
+	}
+}
+
+func TestCompressETag(t *testing.T) {
+	app := newTestApplication(t)
+	body := strings.Repeat("a", 2*compressMinSize)
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != `"v1"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	ts := newTestServer(t, h)
+	gzipOnly := func(header, value string) http.Header {
+		return http.Header{"Accept-Encoding": {"gzip"}, header: {value}}
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		headers  http.Header
+		wantCode int
+		wantETag string
+	}{
+		{"Compressed", http.MethodGet, http.Header{"Accept-Encoding": {"gzip"}}, http.StatusOK, `"v1-gzip"`},
+		{"Uncompressed", http.MethodGet, http.Header{"Accept-Encoding": {"identity"}}, http.StatusOK, `"v1"`},
+		{"If-None-Match compressed", http.MethodGet, gzipOnly("If-None-Match", `"v1-gzip"`), http.StatusNotModified, `"v1-gzip"`},
+		{"If-None-Match other encoding", http.MethodGet, gzipOnly("If-None-Match", `"v1-br"`), http.StatusNotModified, `"v1-br"`},
+		{"If-None-Match uncompressed", http.MethodGet, gzipOnly("If-None-Match", `"v1"`), http.StatusNotModified, `"v1"`},
+		{"If-Match compressed", http.MethodPut, gzipOnly("If-Match", `"v1-gzip"`), http.StatusOK, `"v1-gzip"`},
+		{"If-Match stale", http.MethodPut, gzipOnly("If-Match", `"v0-gzip"`), http.StatusPreconditionFailed, `"v1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.do(t, tt.method, "/", "", tt.headers)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d", code, tt.wantCode)
+			}
+			if got := headers.Get("ETag"); got != tt.wantETag {
+				t.Errorf("got ETag %q; want %q", got, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestCompressSkipsCompressedTypes(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bytes.Repeat([]byte{0}, 2*compressMinSize))
+	}))
+	ts := newTestServer(t, h)
+
+	_, headers, body := ts.do(t, http.MethodGet, "/", "", http.Header{"Accept-Encoding": {"gzip"}})
+	if got := headers.Get("Content-Encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q; want none", got)
+	}
+	if len(body) != 2*compressMinSize {
+		t.Errorf("got %d bytes; want %d", len(body), 2*compressMinSize)
+	}
+}
+
+func TestCompressRevalidateSmallBody(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+	gzipOnly := http.Header{"Accept-Encoding": {"gzip"}}
+
+	// The movie is too small to be compressed, so it keeps its plain ETag...
+	code, headers, _ := ts.do(t, http.MethodGet, "/v1/movies/1", "", gzipOnly)
+	if code != http.StatusOK || headers.Get("Content-Encoding") != "" {
+		t.Fatalf("got status %d and Content-Encoding %q; want %d uncompressed", code, headers.Get("Content-Encoding"), http.StatusOK)
+	}
+	etag := headers.Get("ETag")
+
+	// ...which a revalidation must send back unchanged.
+	code, headers, _ = ts.do(t, http.MethodGet, "/v1/movies/1", "", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}})
+	if code != http.StatusNotModified {
+		t.Fatalf("got status %d; want %d", code, http.StatusNotModified)
+	}
+	if got := headers.Get("ETag"); got != etag {
+		t.Errorf("got ETag %q; want %q", got, etag)
+	}
+}
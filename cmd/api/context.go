@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// Define a custom contextKey type, with the underlying type string, so that the keys
+// we store in the request context can't collide with keys set by other packages.
+type contextKey string
+
+// Keys for the request-scoped values that our middleware and handlers share.
+const (
+	requestIDContextKey = contextKey("requestID")
+	modelsContextKey    = contextKey("models")
+)
+
+// The contextSetRequestID() method returns a new copy of the request with the provided
+// request ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method retrieves the request ID from the request context.
+// It returns an empty string if no request ID has been set.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// The contextSetModels() method returns a new copy of the request whose handlers use
+// the provided models instead of app.models, e.g. models bound to the transaction of an
+// atomic batch.
+func (app *application) contextSetModels(r *http.Request, models data.Models) *http.Request {
+	ctx := context.WithValue(r.Context(), modelsContextKey, models)
+	return r.WithContext(ctx)
+}
+
+// The modelsFor() method returns the models the handlers of the request must use: the
+// ones set by contextSetModels(), or else app.models.
+func (app *application) modelsFor(r *http.Request) data.Models {
+	if models, ok := r.Context().Value(modelsContextKey).(data.Models); ok {
+		return models
+	}
+	return app.models
+}
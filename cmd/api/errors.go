@@ -3,17 +3,48 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
-// The logError() method is a generic helper for logging an error message.
+// The logError() method is a generic helper for logging an error message along
+// with the current request method, URL and request ID as properties in the log entry.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Print(err)
+	properties := map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+	}
+	if id := app.contextGetRequestID(r); id != "" {
+		properties["request_id"] = id
+	}
+	app.logger.PrintError(err, properties)
 }
 
 // The errorResponse() method is a generic helper for sending JSON-formatted error
 // messages to the client with a given status code. CHANGE "interface" to "any" if go version is 1.18 or newer
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	app.problemResponse(w, r, status, problemTypes[status], message)
+}
+
+// The problemResponse() method sends an error of the given problem type (see
+// problem.go). Clients which asked for it, or all of them if -error-format is
+// "problem", get an RFC 7807 application/problem+json document. The others get the
+// {"error": message} object.
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, problemType string, message interface{}) {
+	if app.wantsProblem(r) {
+		err := app.writeProblem(w, r, status, problemType, message)
+		if err != nil {
+			app.logError(r, err)
+			w.WriteHeader(500)
+		}
+		return
+	}
+
 	env := envelope{"error": message}
+	// Include the request ID, so that clients can quote it when reporting a problem
+	// and we can find the matching entries in our logs.
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
@@ -48,11 +79,52 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	message := "bad Request Response ERROR:400"
-	app.errorResponse(w, r, http.StatusBadRequest, message)
+	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+// The preconditionFailedResponse() method sends a 412 Precondition Failed status code
+// when the client's If-Match or X-Expected-Version header doesn't match the current
+// version of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you last fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// The failedValidationResponse() method sends a 422 Unprocessable Entity status code
+// with the invalid fields, keyed by their JSON name, in the "error" field.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+}
+
+// The notAcceptableResponse() method sends a 406 Not Acceptable status code when none
+// of the formats the resource is available in is acceptable to the client.
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, available []string) {
+	message := fmt.Sprintf("the requested resource is only available as %s", strings.Join(available, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
+// The unsupportedMediaTypeResponse() method sends a 415 Unsupported Media Type status
+// code when the request body is in a format the endpoint doesn't accept.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The idempotencyKeyInUseResponse() method sends a 409 Conflict status code when a
+// request reuses the Idempotency-Key of a request which is still being handled.
+func (app *application) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this Idempotency-Key is still being processed, please try again later"
+	app.problemResponse(w, r, http.StatusConflict, problemIdempotencyKeyInUse, message)
+}
+
+// The idempotencyKeyReusedResponse() method sends a 422 Unprocessable Entity status code
+// when a request reuses the Idempotency-Key of a different request.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this Idempotency-Key has already been used for a different request"
+	app.problemResponse(w, r, http.StatusUnprocessableEntity, problemIdempotencyKeyReused, message)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// cacheControl tells caches (our CDN, the mobile clients) that they may store the
+// responses, but must revalidate them with a conditional request before reusing them.
+const cacheControl = "public, max-age=0, must-revalidate"
+
+// movieETag returns the strong entity tag of a movie. The representation of a movie
+// only changes when its version is incremented, so its ID and version identify it.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d"`, movie.ID, movie.Version)
+}
+
+// weakETag returns a weak entity tag computed from the JSON encoding of v. It is used
+// for the list responses, which have no version of their own. The tag is weak because
+// it only promises that the content is the same, not the exact bytes sent (which may
+// for instance be compressed).
+func weakETag(v any) (string, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write(js)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64()), nil
+}
+
+// The representationETag() helper returns the entity tag of the representation sent
+// to the client. The same record encoded in another format than JSON (see
+// writeResponse) is another representation, so its tag gets the format as a suffix,
+// e.g. "1-2-xml".
+func representationETag(r *http.Request, etag string) string {
+	mediaType := negotiate(r.Header.Get("Accept"), []string{mediaJSON, mediaXML, mediaMsgPack, mediaCSV})
+	if mediaType == mediaJSON || mediaType == "" {
+		return etag
+	}
+	suffix := mediaType[strings.LastIndex(mediaType, "/")+1:]
+	return strings.TrimSuffix(etag, `"`) + "-" + suffix + `"`
+}
+
+// The notModified() helper sets the ETag, Last-Modified (unless lastModified is zero)
+// and Cache-Control headers of a GET response, and checks the If-None-Match and
+// If-Modified-Since headers of the request. If the copy the client holds is still
+// fresh, it sends a 304 Not Modified response and returns true, in which case the
+// handler must not write anything else.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	etag = representationETag(r, etag)
+	addVary(w.Header(), "Accept")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is only used when the client didn't send If-None-Match, and
+	// has a one second precision (RFC 7232, section 6).
+	fresh := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		fresh = etagMatch(inm, etag, false)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		fresh = err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	if !fresh {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch reports whether etag is in header, a comma-separated list of entity tags
+// or "*" as sent in the If-Match and If-None-Match headers. If-None-Match uses the weak
+// comparison, which ignores the W/ prefixes, and If-Match the strong comparison, where
+// weak tags never match.
+func etagMatch(header, etag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong {
+			if candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// The checkPrecondition() helper is used by the update handlers to make sure that the
+// client is updating the version of the movie it last fetched. The expected version is
+// given either as the movie's ETag in an If-Match header, or as a number in an
+// X-Expected-Version header. If it doesn't match the current version, a 412
+// Precondition Failed response is sent and false is returned. Requests without either
+// header are let through.
+func (app *application) checkPrecondition(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatch(ifMatch, representationETag(r, movieETag(movie)), true) {
+			app.preconditionFailedResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		version, err := strconv.ParseInt(expected, 10, 32)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("X-Expected-Version must be an integer"))
+			return false
+		}
+		if int32(version) != movie.Version {
+			app.preconditionFailedResponse(w, r)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestConditionalGet(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	for _, urlPath := range []string{"/v1/movies/1", "/v1/movies", "/v1/directors"} {
+		t.Run(urlPath, func(t *testing.T) {
+			code, headers, _ := ts.do(t, http.MethodGet, urlPath, "", nil)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d; want %d", code, http.StatusOK)
+			}
+			etag := headers.Get("ETag")
+			if etag == "" {
+				t.Fatal("got no ETag header")
+			}
+			if got := headers.Get("Cache-Control"); got != cacheControl {
+				t.Errorf("got Cache-Control %q; want %q", got, cacheControl)
+			}
+
+			code, _, body := ts.do(t, http.MethodGet, urlPath, "", http.Header{"If-None-Match": {etag}})
+			if code != http.StatusNotModified || len(body) != 0 {
+				t.Errorf("got status %d with %d bytes for a matching ETag; want %d with no body", code, len(body), http.StatusNotModified)
+			}
+
+			code, _, _ = ts.do(t, http.MethodGet, urlPath, "", http.Header{"If-None-Match": {`"stale"`}})
+			if code != http.StatusOK {
+				t.Errorf("got status %d for a stale ETag; want %d", code, http.StatusOK)
+			}
+		})
+	}
+
+	// The movie's ETag changes with its version.
+	_, headers, _ := ts.do(t, http.MethodGet, "/v1/movies/1", "", nil)
+	if got := headers.Get("ETag"); got != `"1-1"` {
+		t.Errorf("got ETag %q; want %q", got, `"1-1"`)
+	}
+	lastModified, err := http.ParseTime(headers.Get("Last-Modified"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, _ := ts.do(t, http.MethodGet, "/v1/movies/1", "", http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}})
+	if code != http.StatusNotModified {
+		t.Errorf("got status %d for If-Modified-Since; want %d", code, http.StatusNotModified)
+	}
+	code, _, _ = ts.do(t, http.MethodGet, "/v1/movies/1", "", http.Header{"If-Modified-Since": {lastModified.Add(-time.Second).Format(http.TimeFormat)}})
+	if code != http.StatusOK {
+		t.Errorf("got status %d for an older If-Modified-Since; want %d", code, http.StatusOK)
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		strong bool
+		want   bool
+	}{
+		{`"1-1"`, `"1-1"`, false, true},
+		{`"1-0", "1-1"`, `"1-1"`, false, true},
+		{`W/"1-1"`, `"1-1"`, false, true},
+		{`W/"1-1"`, `"1-1"`, true, false},
+		{`"1-2"`, `"1-1"`, false, false},
+		{`*`, `"1-1"`, true, true},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatch(tt.header, tt.etag, tt.strong); got != tt.want {
+			t.Errorf("etagMatch(%q, %q, %t) = %t; want %t", tt.header, tt.etag, tt.strong, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateMoviePreconditions(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	const body = `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`
+
+	tests := []struct {
+		name     string
+		method   string
+		headers  http.Header
+		wantCode int
+		wantETag string
+	}{
+		{"Stale If-Match", http.MethodPut, http.Header{"If-Match": {`"1-0"`}}, http.StatusPreconditionFailed, ""},
+		{"Weak If-Match", http.MethodPut, http.Header{"If-Match": {`W/"1-1"`}}, http.StatusPreconditionFailed, ""},
+		{"Current If-Match", http.MethodPut, http.Header{"If-Match": {`"1-1"`}}, http.StatusOK, `"1-2"`},
+		{"Stale X-Expected-Version", http.MethodPatch, http.Header{"X-Expected-Version": {"1"}}, http.StatusPreconditionFailed, ""},
+		{"Invalid X-Expected-Version", http.MethodPatch, http.Header{"X-Expected-Version": {"two"}}, http.StatusBadRequest, ""},
+		{"Current X-Expected-Version", http.MethodPatch, http.Header{"X-Expected-Version": {"2"}}, http.StatusOK, `"1-3"`},
+		{"No precondition", http.MethodPatch, nil, http.StatusOK, `"1-4"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.do(t, tt.method, "/v1/movies/1", body, tt.headers)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d", code, tt.wantCode)
+			}
+			if got := headers.Get("ETag"); got != tt.wantETag {
+				t.Errorf("got ETag %q; want %q", got, tt.wantETag)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestExportMovies(t *testing.T) {
+	app := newTestApplication(t)
+	const n = 2*exportFlushEvery + 50
+	for i := 1; i <= n; i++ {
+		genre := "drama"
+		if i%2 == 0 {
+			genre = "comedy"
+		}
+		seedMovies(t, app, &data.Movie{Title: fmt.Sprintf("Movie %d", i), Year: 2000, Runtime: int32(60 + i), Genres: []string{genre}})
+	}
+	ts := newTestServer(t, app.routes())
+
+	t.Run("NDJSON", func(t *testing.T) {
+		code, headers, body := ts.do(t, http.MethodGet, "/v1/movies/export", "", nil)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d; want %d", code, http.StatusOK)
+		}
+		if got := headers.Get("Content-Type"); got != mediaNDJSON {
+			t.Errorf("got Content-Type %q; want %q", got, mediaNDJSON)
+		}
+
+		lines := 0
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			lines++
+			var movie data.Movie
+			decode(t, scanner.Bytes(), &movie)
+			if movie.ID != int64(lines) {
+				t.Fatalf("line %d: got movie %d", lines, movie.ID)
+			}
+		}
+		if lines != n {
+			t.Errorf("got %d movies; want %d", lines, n)
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		code, headers, body := ts.do(t, http.MethodGet, "/v1/movies/export?format=csv&genres=comedy&sort=-runtime", "", nil)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d; want %d", code, http.StatusOK)
+		}
+		if got := headers.Get("Content-Disposition"); got != `attachment; filename="movies.csv"` {
+			t.Errorf("got Content-Disposition %q", got)
+		}
+
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"id", "title", "year", "runtime", "genres", "version"}; !reflect.DeepEqual(records[0], want) {
+			t.Errorf("got header %q; want %q", records[0], want)
+		}
+		if len(records) != n/2+1 {
+			t.Fatalf("got %d records; want %d", len(records), n/2+1)
+		}
+		if want := []string{fmt.Sprint(n), fmt.Sprintf("Movie %d", n), "2000", fmt.Sprint(60 + n), "comedy", "1"}; !reflect.DeepEqual(records[1], want) {
+			t.Errorf("got first row %q; want %q", records[1], want)
+		}
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		code, headers, body := ts.do(t, http.MethodGet, "/v1/movies/export", "", http.Header{"Accept-Encoding": {"gzip"}})
+		if code != http.StatusOK || headers.Get("Content-Encoding") != "gzip" {
+			t.Fatalf("got status %d and Content-Encoding %q", code, headers.Get("Content-Encoding"))
+		}
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := bytes.Count(plain, []byte("\n")); lines != n {
+			t.Errorf("got %d movies; want %d", lines, n)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodGet, "/v1/movies/export?format=csv&title=nothing", "", nil)
+		if code != http.StatusOK || string(body) != "id,title,year,runtime,genres,version\n" {
+			t.Errorf("got status %d and body %q", code, body)
+		}
+	})
+
+	for _, query := range []string{"format=xml", "sort=rating"} {
+		t.Run(query, func(t *testing.T) {
+			code, _, _ := ts.do(t, http.MethodGet, "/v1/movies/export?"+query, "", nil)
+			if code != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d; want %d", code, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+}
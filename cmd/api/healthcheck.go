@@ -1,9 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
 )
 
+// readinessTimeout bounds how long the readiness probe waits on each dependency.
+const readinessTimeout = 2 * time.Second
+
+// The healthcheckHandler() serves the liveness probe. It only reports that the process
+// is up and able to serve HTTP requests, and deliberately doesn't touch any dependency:
+// a database outage should take the instance out of rotation, not get it restarted.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
 		"status": "available",
@@ -21,3 +32,76 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 }
+
+// dependencyStatus describes the result of checking a single dependency in the
+// readiness probe.
+type dependencyStatus struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Version int64  `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// The readinessHandler() serves the readiness probe. It pings the database and checks
+// the migration version, and responds with 503 Service Unavailable if any of the checks
+// fail or if the server is draining connections before shutting down.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ready := true
+	checks := map[string]dependencyStatus{}
+
+	// Check that the database is reachable.
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	start := time.Now()
+	err := app.models.Health.Ping(ctx)
+	cancel()
+	database := dependencyStatus{Status: "up", Latency: time.Since(start).String()}
+	if err != nil {
+		database.Status = "down"
+		database.Error = err.Error()
+		ready = false
+	}
+	checks["database"] = database
+
+	// Check that all the migrations this build relies on have been applied.
+	ctx, cancel = context.WithTimeout(r.Context(), readinessTimeout)
+	start = time.Now()
+	schemaVersion, err := app.models.Health.SchemaVersion(ctx)
+	cancel()
+	migrations := dependencyStatus{Status: "up", Latency: time.Since(start).String(), Version: schemaVersion}
+	if err == nil && schemaVersion < data.RequiredSchemaVersion {
+		err = fmt.Errorf("schema version %d is older than required version %d", schemaVersion, data.RequiredSchemaVersion)
+	}
+	if err != nil {
+		migrations.Status = "down"
+		migrations.Error = err.Error()
+		ready = false
+	}
+	checks["migrations"] = migrations
+
+	status := "ready"
+	if app.shuttingDown.Load() {
+		status = "shutting down"
+		ready = false
+	} else if !ready {
+		status = "unavailable"
+	}
+
+	env := envelope{
+		"status": status,
+		"checks": checks,
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	err = app.writeJSON(w, code, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
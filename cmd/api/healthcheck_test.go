@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHealthcheck(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	for _, path := range []string{"/v1/healthcheck", "/v1/healthz"} {
+		code, _, body := ts.do(t, http.MethodGet, path, "", nil)
+		if code != http.StatusOK {
+			t.Errorf("%s: got status %d; want %d", path, code, http.StatusOK)
+		}
+
+		var rs struct {
+			Status string `json:"status"`
+		}
+		decode(t, body, &rs)
+		if rs.Status != "available" {
+			t.Errorf("%s: got status %q; want %q", path, rs.Status, "available")
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	code, _, body := ts.do(t, http.MethodGet, "/v1/readyz", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+
+	var rs struct {
+		Status string                      `json:"status"`
+		Checks map[string]dependencyStatus `json:"checks"`
+	}
+	decode(t, body, &rs)
+	if rs.Status != "ready" {
+		t.Errorf("got status %q; want %q", rs.Status, "ready")
+	}
+	for _, name := range []string{"database", "migrations"} {
+		if rs.Checks[name].Status != "up" {
+			t.Errorf("got %s status %q; want %q", name, rs.Checks[name].Status, "up")
+		}
+	}
+
+	// Once the server starts draining, the probe must report it as not ready.
+	app.shuttingDown.Store(true)
+
+	code, _, _ = ts.do(t, http.MethodGet, "/v1/readyz", "", nil)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d while shutting down; want %d", code, http.StatusServiceUnavailable)
+	}
+}
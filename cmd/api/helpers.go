@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -76,24 +77,40 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 
 	js = append(js, '\n')
 
+	// Adding Content-Type to the header as json, unless the additional headers below
+	// set another one (e.g. application/problem+json)
+	w.Header().Set("Content-Type", "application/json")
+
 	//adding additional headers if there are any to be added
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	// Adding Content-Type and status code to header and response as json
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	w.Write(js)
 	return nil
 }
 
+// The readJSON() helper decodes the request body into dst. The body must hold a single
+// JSON value, no larger than the -max-body-bytes limit, whose keys all match a field
+// of dst. Each kind of problem is reported with its own error message, which is safe
+// to send back to the client.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	err := json.NewDecoder(r.Body).Decode(dst)
+	// Use http.MaxBytesReader() to limit the size of the request body. Reading past
+	// the limit fails with a *http.MaxBytesError.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.maxBodyBytes)
+
+	// Reject the keys which don't match a field of dst, rather than silently ignoring
+	// them.
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
 	if err != nil {
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
 		if errors.As(err, &syntaxError) {
 			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
 		} else if errors.As(err, &unmarshalTypeError) {
@@ -111,10 +128,42 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 		} else if errors.Is(err, io.EOF) {
 			return errors.New("body must not be empty")
 
+		} else if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			// There is no distinct error type for unknown fields yet, so the key is
+			// extracted from the error message.
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("body contains unknown key %s", fieldName)
+
+		} else if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+
 		} else {
 			return err
 		}
 	}
 
+	// Decode again, into an anonymous empty struct. If the body only contained a
+	// single JSON value this returns io.EOF, anything else means that there is more
+	// data after it.
+	err = dec.Decode(&struct{}{})
+	if err != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+
 	return nil
 }
+
+// bulkResponseGrace is how long a bulk request has left to write its response once
+// -bulk-timeout has expired, e.g. the report of an import which timed out.
+const bulkResponseGrace = 10 * time.Second
+
+// The extendDeadlines() helper lets a bulk request run past the read and write timeouts
+// of the server, which are meant for the other requests, up to the -bulk-timeout limit.
+func (app *application) extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.config.bulk.timeout)
+	// These fail if the underlying connection doesn't support deadlines, in which case
+	// there is no deadline to extend anyway.
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline.Add(bulkResponseGrace))
+}
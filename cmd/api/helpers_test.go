@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadJSON(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.maxBodyBytes = 128
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name      string
+		body      string
+		wantError string
+	}{
+		{"Empty body", "", "body must not be empty"},
+		{"Badly-formed JSON", `{"title": "Moana",}`, "body contains badly-formed JSON (at character 19)"},
+		{"Truncated JSON", `{"title": "Moana"`, "body contains badly-formed JSON"},
+		{"Wrong type", `{"title": 42}`, `body contains incorrect JSON type for field "title"`},
+		{"Unknown key", `{"title": "Moana", "rating": 5}`, `body contains unknown key "rating"`},
+		{"Several values", `{"title": "Moana"} {"title": "Up"}`, "body must only contain a single JSON value"},
+		{"Too large", `{"title": "` + strings.Repeat("a", 200) + `"}`, "body must not be larger than 128 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/v1/movies", tt.body, nil)
+			if code != http.StatusBadRequest {
+				t.Fatalf("got status %d; want %d", code, http.StatusBadRequest)
+			}
+
+			var rs struct {
+				Error string `json:"error"`
+			}
+			decode(t, body, &rs)
+			if rs.Error != tt.wantError {
+				t.Errorf("got error %q; want %q", rs.Error, tt.wantError)
+			}
+		})
+	}
+
+	// Nothing was created by the rejected requests.
+	_, _, body := ts.do(t, http.MethodGet, "/v1/movies", "", nil)
+	var rs struct {
+		Movies []any `json:"movies"`
+	}
+	decode(t, body, &rs)
+	if len(rs.Movies) != 0 {
+		t.Errorf("got %d movies; want none", len(rs.Movies))
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxIdempotencyKeyLength is the maximum length of an Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyPurgeInterval is how often deleteExpiredIdempotencyKeys() runs.
+const idempotencyPurgeInterval = time.Hour
+
+// replayedHeaders are the response headers stored with an idempotency key, and sent
+// again when the response is replayed. The others (e.g. X-Request-ID) belong to each
+// request.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+// The idempotent() middleware makes a create endpoint safe to retry. A client sends a
+// unique Idempotency-Key header (e.g. a UUID) with the request, and the same header
+// when it retries it. The first request with a key is handled as usual, and its
+// response is stored with the key for -idempotency-ttl. A retry with the same method,
+// path and body gets the stored response back, with an Idempotent-Replayed: true
+// header, instead of creating a duplicate record.
+//
+// A key reused for a different request is rejected with 422 Unprocessable Entity, and
+// one reused while the first request is still being handled with 409 Conflict. Server
+// errors aren't stored, so that the client can retry them for real.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := app.idempotencyKey(w, r)
+		if !ok {
+			return
+		}
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		// Read the body to hash it, and hand a copy of it to the handler. The limit is
+		// the one readJSON() enforces anyway.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, app.config.maxBodyBytes))
+		if err != nil {
+			app.badRequestResponse(w, r, readError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		app.serveIdempotent(w, r, next, key, idempotencyHash(r.Method, r.URL.RequestURI(), body))
+	}
+}
+
+// The idempotentBulk() middleware is idempotent() for the bulk endpoints, such as POST
+// /v1/movies/import, whose body may be up to -bulk-max-bytes long. The body is spooled
+// to a temporary file rather than kept in memory while it is hashed, and the deadlines
+// are extended first, as reading it can take a while. A body over the limit is rejected
+// as a whole, whereas without a key the rows before the limit would be imported.
+func (app *application) idempotentBulk(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := app.idempotencyKey(w, r)
+		if !ok {
+			return
+		}
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		app.extendDeadlines(w)
+		f, err := os.CreateTemp("", "greenlight-body-*")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+
+		h := newIdempotencyHash(r.Method, r.URL.RequestURI())
+		_, err = io.Copy(io.MultiWriter(f, h), http.MaxBytesReader(w, r.Body, app.config.bulk.maxBytes))
+		if err != nil {
+			app.badRequestResponse(w, r, readError(err))
+			return
+		}
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(f)
+
+		app.serveIdempotent(w, r, next, key, hex.EncodeToString(h.Sum(nil)))
+	}
+}
+
+// The idempotencyKey() helper returns the Idempotency-Key header of the request, or ""
+// if there is none. If the header is invalid, it sends a 400 Bad Request response and
+// returns false.
+func (app *application) idempotencyKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.Header.Get("Idempotency-Key")
+	if key != "" && !validIdempotencyKey(key) {
+		app.badRequestResponse(w, r, errors.New("the Idempotency-Key header must hold up to 255 printable ASCII characters"))
+		return "", false
+	}
+	return key, true
+}
+
+// The serveIdempotent() method reserves key for the request whose hash is requestHash,
+// and either replays the response stored with the key or calls next and stores its
+// response.
+func (app *application) serveIdempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, key, requestHash string) {
+	keys := app.modelsFor(r).Idempotency
+	record, err := keys.Reserve(r.Context(), key, requestHash, app.config.idempotency.ttl)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if record != nil {
+		switch {
+		case record.RequestHash != requestHash:
+			app.idempotencyKeyReusedResponse(w, r)
+		case record.Status == 0:
+			app.idempotencyKeyInUseResponse(w, r)
+		default:
+			for key, values := range record.Header {
+				w.Header()[key] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+		}
+		return
+	}
+
+	// The outcome is saved even if the client has gone away in the meantime, as it
+	// is the client most likely to retry.
+	ctx := context.Background()
+	rec := &idempotencyRecorder{ResponseWriter: w}
+	defer func() {
+		// Free the key if the handler panics, so that it can be retried.
+		if p := recover(); p != nil {
+			keys.Release(ctx, key)
+			panic(p)
+		}
+	}()
+	next(rec, r)
+
+	if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+		err = keys.Release(ctx, key)
+	} else {
+		header := make(http.Header)
+		for _, name := range replayedHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				header[name] = values
+			}
+		}
+		err = keys.Complete(ctx, key, rec.status, header, rec.body.Bytes())
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// validIdempotencyKey reports whether key is a valid Idempotency-Key header value.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyHash returns the hash identifying a request, to tell whether a retry with
+// the same Idempotency-Key is the same request. target is the path and query string of
+// the request, as the query string can change what it does (e.g. ?atomic=true).
+func idempotencyHash(method, target string, body []byte) string {
+	h := newIdempotencyHash(method, target)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newIdempotencyHash returns the hash.Hash behind idempotencyHash(), which the body of
+// the request has yet to be written to.
+func newIdempotencyHash(method, target string) hash.Hash {
+	h := sha256.New()
+	io.WriteString(h, method+" "+target+"\n")
+	return h
+}
+
+// idempotencyRecorder wraps an http.ResponseWriter and keeps a copy of the response
+// written through it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController
+// can reach optional interfaces such as http.Flusher.
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// The deleteExpiredIdempotencyKeys() method deletes the expired idempotency keys every
+// idempotencyPurgeInterval, so that the table doesn't grow forever. It blocks until
+// ctx is done.
+func (app *application) deleteExpiredIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := app.models.Idempotency.DeleteExpired(ctx)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				continue
+			}
+			if n > 0 {
+				app.logger.PrintInfo("expired idempotency keys deleted", map[string]string{"count": strconv.FormatInt(n, 10)})
+			}
+		}
+	}
+}
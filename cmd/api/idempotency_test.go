@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestIdempotent(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	moana := `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`
+	withKey := func(key string) http.Header {
+		return http.Header{"Content-Type": {"application/json"}, "Idempotency-Key": {key}}
+	}
+
+	code, headers, body := ts.do(t, http.MethodPost, "/v1/movies", moana, withKey("create-moana"))
+	if code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", code, http.StatusCreated)
+	}
+	if got := headers.Get("Idempotent-Replayed"); got != "" {
+		t.Errorf("got Idempotent-Replayed %q on the first response", got)
+	}
+
+	// A retry gets the same response, and doesn't create another movie.
+	retryCode, retryHeaders, retryBody := ts.do(t, http.MethodPost, "/v1/movies", moana, withKey("create-moana"))
+	if retryCode != code || !bytes.Equal(retryBody, body) {
+		t.Errorf("got %d %s on retry; want %d %s", retryCode, retryBody, code, body)
+	}
+	if got := retryHeaders.Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("got Idempotent-Replayed %q on retry; want %q", got, "true")
+	}
+	if got, want := retryHeaders.Get("Location"), headers.Get("Location"); got != want {
+		t.Errorf("got Location %q on retry; want %q", got, want)
+	}
+	if _, err := app.models.Movies.Get(context.Background(), 2); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v for a second movie; want %v", err, data.ErrRecordNotFound)
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		body     string
+		headers  http.Header
+		wantCode int
+	}{
+		{"Other body", "/v1/movies", strings.Replace(moana, "Moana", "Coco", 1), withKey("create-moana"), http.StatusUnprocessableEntity},
+		{"Invalid key", "/v1/movies", moana, withKey("café"), http.StatusBadRequest},
+		{"Key too long", "/v1/movies", moana, withKey(strings.Repeat("a", maxIdempotencyKeyLength+1)), http.StatusBadRequest},
+		{"No key", "/v1/movies", moana, http.Header{"Content-Type": {"application/json"}}, http.StatusCreated},
+		{"Client error", "/v1/movies", `{"title": `, withKey("malformed"), http.StatusBadRequest},
+		{"Client error replayed", "/v1/movies", `{"title": `, withKey("malformed"), http.StatusBadRequest},
+		{"Batch", "/v1/batch?atomic=true", `[{"method": "GET", "path": "/v1/movies/1"}]`, withKey("batch"), http.StatusOK},
+		{"Other query", "/v1/batch?atomic=false", `[{"method": "GET", "path": "/v1/movies/1"}]`, withKey("batch"), http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, tt.urlPath, tt.body, tt.headers)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+}
+
+func TestIdempotentInUse(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	// Reserve the key as a request still being handled would.
+	moana := `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`
+	hash := idempotencyHash(http.MethodPost, "/v1/movies", []byte(moana))
+	if _, err := app.models.Idempotency.Reserve(context.Background(), "in-use", hash, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, body := ts.do(t, http.MethodPost, "/v1/movies", moana, http.Header{"Content-Type": {"application/json"}, "Idempotency-Key": {"in-use"}})
+	if code != http.StatusConflict {
+		t.Errorf("got status %d; want %d (body %s)", code, http.StatusConflict, body)
+	}
+}
+
+func TestIdempotentBulk(t *testing.T) {
+	tests := []struct {
+		name        string
+		urlPath     string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "Import",
+			urlPath:     "/v1/movies/import",
+			contentType: "application/x-ndjson",
+			body:        `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}` + "\n",
+		},
+		{
+			name:        "Batch",
+			urlPath:     "/v1/batch",
+			contentType: "application/json",
+			body:        `[{"method": "POST", "path": "/v1/movies", "body": {"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			headers := http.Header{"Content-Type": {tt.contentType}, "Idempotency-Key": {"bulk"}}
+
+			code, _, body := ts.do(t, http.MethodPost, tt.urlPath, tt.body, headers)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+			}
+
+			// A retry gets the same response, and doesn't create the movie again.
+			retryCode, retryHeaders, retryBody := ts.do(t, http.MethodPost, tt.urlPath, tt.body, headers)
+			if retryCode != code || !bytes.Equal(retryBody, body) {
+				t.Errorf("got %d %s on retry; want %d %s", retryCode, retryBody, code, body)
+			}
+			if got := retryHeaders.Get("Idempotent-Replayed"); got != "true" {
+				t.Errorf("got Idempotent-Replayed %q on retry; want %q", got, "true")
+			}
+			if _, err := app.models.Movies.Get(context.Background(), 2); err != data.ErrRecordNotFound {
+				t.Errorf("got error %v for a second movie; want %v", err, data.ErrRecordNotFound)
+			}
+
+			// The body is part of the request hash.
+			code, _, _ = ts.do(t, http.MethodPost, tt.urlPath, strings.Replace(tt.body, "Moana", "Coco", 1), headers)
+			if code != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d for another body; want %d", code, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+}
+
+func TestIdempotentBulkTooLarge(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.bulk.maxBytes = 100
+	ts := newTestServer(t, app.routes())
+
+	// With a key, the body has to be read in full before the import starts.
+	body := strings.Repeat(`{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`+"\n", 3)
+	code, _, _ := ts.do(t, http.MethodPost, "/v1/movies/import", body, http.Header{"Content-Type": {"application/x-ndjson"}, "Idempotency-Key": {"large"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("got status %d; want %d", code, http.StatusBadRequest)
+	}
+	if _, err := app.models.Movies.Get(context.Background(), 1); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v for an imported movie; want %v", err, data.ErrRecordNotFound)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mediaNDJSON is the media type of newline-delimited JSON: one JSON value per line.
+const mediaNDJSON = "application/x-ndjson"
+
+// importBatchSize is the number of movies inserted by each InsertBatch() call of an
+// import.
+const importBatchSize = 500
+
+// errUnsupportedImport is returned by newMovieRowReader() when the Content-Type of the
+// request isn't a format the import accepts.
+var errUnsupportedImport = errors.New("unsupported import format")
+
+// importRow is the outcome of importing one row of the body. Line is the line of the
+// body the row starts on, so that clients can find it in their file.
+type importRow struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Title  string            `json:"title,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// The statuses of an importRow.
+const (
+	importImported = "imported"
+	importFailed   = "failed"
+)
+
+// importReport is the response of POST /v1/movies/import. Error is set if the body
+// couldn't be read to the end, in which case the rows before the problem have been
+// imported nonetheless.
+type importReport struct {
+	Total    int         `json:"total"`
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Error    string      `json:"error,omitempty"`
+	Rows     []importRow `json:"rows"`
+}
+
+// rowError is returned by a movieRowReader for a row which can't be parsed. Unlike the
+// other errors, it doesn't stop the import.
+type rowError struct {
+	line int
+	errs map[string]string
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("line %d is invalid", e.line)
+}
+
+// movieRowReader reads the movies of an import body one row at a time. The next()
+// method returns the line the row starts on and its fields, a *rowError if the row
+// can't be parsed, or io.EOF once the body has been read.
+type movieRowReader interface {
+	next() (int, movieInput, error)
+}
+
+// newMovieRowReader returns the movieRowReader for the format of the request body,
+// chosen by its Content-Type header:
+//
+//   - text/csv is a CSV file whose header row names the title, year, runtime and
+//     genres columns, in any order. The genres are separated by commas, as in the CSV
+//     responses of GET /v1/movies.
+//   - application/x-ndjson holds one JSON object per line, with the same keys as the
+//     body of POST /v1/movies. Blank lines are skipped.
+//
+// The body is read as the rows are, so that it never has to fit in memory, up to the
+// -bulk-max-bytes limit.
+func (app *application) newMovieRowReader(w http.ResponseWriter, r *http.Request) (movieRowReader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errUnsupportedImport
+	}
+
+	body := http.MaxBytesReader(w, r.Body, app.config.bulk.maxBytes)
+	switch mediaType {
+	case mediaCSV:
+		return newCSVRowReader(body)
+	case mediaNDJSON, "application/ndjson":
+		return &ndjsonRowReader{r: bufio.NewReader(body)}, nil
+	default:
+		return nil, errUnsupportedImport
+	}
+}
+
+// csvColumns are the columns an import CSV file must have.
+var csvColumns = []string{"title", "year", "runtime", "genres"}
+
+type csvRowReader struct {
+	r       *csv.Reader
+	columns map[string]int // index of each column in the records
+}
+
+// newCSVRowReader reads the header row of a CSV body.
+func newCSVRowReader(body io.Reader) (*csvRowReader, error) {
+	cr := csv.NewReader(body)
+	header, err := cr.Read()
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil, errors.New("body must not be empty")
+	case err != nil:
+		return nil, readError(err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !contains(csvColumns, name) {
+			return nil, fmt.Errorf("body contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("body must contain a %q column", name)
+		}
+	}
+
+	return &csvRowReader{r: cr, columns: columns}, nil
+}
+
+func (cr *csvRowReader) next() (int, movieInput, error) {
+	var input movieInput
+
+	record, err := cr.r.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return parseError.StartLine, input, &rowError{line: parseError.StartLine, errs: map[string]string{"row": parseError.Err.Error()}}
+		}
+		return 0, input, readError(err)
+	}
+	line, _ := cr.r.FieldPos(0)
+
+	errs := make(map[string]string)
+	input.Title = strings.TrimSpace(record[cr.columns["title"]])
+	for _, field := range []struct {
+		name string
+		dst  *int32
+	}{{"year", &input.Year}, {"runtime", &input.Runtime}} {
+		cell := strings.TrimSpace(record[cr.columns[field.name]])
+		if cell == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(cell, 10, 32)
+		if err != nil {
+			errs[field.name] = "must be an integer"
+			continue
+		}
+		*field.dst = int32(n)
+	}
+	for _, genre := range strings.Split(record[cr.columns["genres"]], ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			input.Genres = append(input.Genres, genre)
+		}
+	}
+
+	if len(errs) > 0 {
+		return line, input, &rowError{line: line, errs: errs}
+	}
+	return line, input, nil
+}
+
+type ndjsonRowReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (nr *ndjsonRowReader) next() (int, movieInput, error) {
+	var input movieInput
+
+	for {
+		b, err := nr.r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, input, readError(err)
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			if err != nil {
+				return 0, input, io.EOF
+			}
+			nr.line++
+			continue
+		}
+		nr.line++
+
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		decodeErr := dec.Decode(&input)
+		if decodeErr == nil && dec.More() {
+			decodeErr = errors.New("more than one JSON value")
+		}
+		if decodeErr != nil {
+			return nr.line, input, &rowError{line: nr.line, errs: ndjsonRowErrors(decodeErr)}
+		}
+		return nr.line, input, nil
+	}
+}
+
+// ndjsonRowErrors describes why a line of an NDJSON body couldn't be decoded, in the
+// same form as the validation errors.
+func ndjsonRowErrors(err error) map[string]string {
+	var unmarshalTypeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+		return map[string]string{unmarshalTypeError.Field: "has an incorrect JSON type"}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		key, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return map[string]string{key: "is not a movie field"}
+	default:
+		return map[string]string{"row": "must be a single JSON object"}
+	}
+}
+
+// readError turns the errors reading a bulk body into messages which are safe to send
+// to the client.
+func readError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	}
+	return fmt.Errorf("unable to read the body: %w", err)
+}
+
+// contains reports whether values holds value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// importStopped describes why an import ended before all of its rows were inserted,
+// given the error of its context.
+func importStopped(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "the import timed out"
+	}
+	return "the import was cancelled"
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestImportMovies(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantRows    []importRow
+	}{
+		{
+			name:        "CSV",
+			contentType: "text/csv",
+			body: "Title,year,runtime,genres\n" +
+				"Moana,2016,107,\"animation, adventure\"\n" +
+				"Up,soon,96,animation\n" +
+				"Coco,2017,105,\n" +
+				"Cars,2006,117\n",
+			wantCode: http.StatusOK,
+			wantRows: []importRow{
+				{Line: 2, Status: importImported, ID: 1, Title: "Moana"},
+				{Line: 3, Status: importFailed, Title: "Up", Errors: map[string]string{"year": "must be an integer"}},
+				{Line: 4, Status: importFailed, Title: "Coco", Errors: map[string]string{"genres": "must be provided"}},
+				{Line: 5, Status: importFailed, Errors: map[string]string{"row": "wrong number of fields"}},
+			},
+		},
+		{
+			name:        "NDJSON",
+			contentType: "application/x-ndjson",
+			body: `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}` + "\n" +
+				"\n" +
+				`{"title": "Up", "year": 2009, "runtime": 96, "genres": ["animation"], "rating": 5}` + "\n" +
+				`{"title": "Coco", "year": "2017"}` + "\n" +
+				`{"title": ` + "\n" +
+				`{"title": "Cars", "year": 2006, "runtime": 117, "genres": ["animation"]}`,
+			wantCode: http.StatusOK,
+			wantRows: []importRow{
+				{Line: 1, Status: importImported, ID: 1, Title: "Moana"},
+				{Line: 3, Status: importFailed, Title: "Up", Errors: map[string]string{"rating": "is not a movie field"}},
+				{Line: 4, Status: importFailed, Title: "Coco", Errors: map[string]string{"year": "has an incorrect JSON type"}},
+				{Line: 5, Status: importFailed, Errors: map[string]string{"row": "must be a single JSON object"}},
+				{Line: 6, Status: importImported, ID: 2, Title: "Cars"},
+			},
+		},
+		{
+			name:        "Unknown CSV column",
+			contentType: "text/csv",
+			body:        "title,year,runtime,genres,rating\n",
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Missing CSV column",
+			contentType: "text/csv",
+			body:        "title,year,runtime\n",
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Unsupported format",
+			contentType: "application/json",
+			body:        `[]`,
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+
+			code, _, body := ts.do(t, http.MethodPost, "/v1/movies/import", tt.body, http.Header{"Content-Type": {tt.contentType}})
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var rs struct {
+				Import importReport `json:"import"`
+			}
+			decode(t, body, &rs)
+			if !reflect.DeepEqual(rs.Import.Rows, tt.wantRows) {
+				t.Errorf("got rows %+v; want %+v", rs.Import.Rows, tt.wantRows)
+			}
+			if rs.Import.Total != len(tt.wantRows) || rs.Import.Imported+rs.Import.Failed != rs.Import.Total {
+				t.Errorf("got totals %d/%d/%d for %d rows", rs.Import.Total, rs.Import.Imported, rs.Import.Failed, len(tt.wantRows))
+			}
+		})
+	}
+}
+
+func TestImportMoviesBatches(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	const n = 2*importBatchSize + 1
+	var body strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&body, `{"title": "Movie %d", "year": 2000, "runtime": 90, "genres": ["drama"]}`+"\n", i)
+	}
+
+	code, _, b := ts.do(t, http.MethodPost, "/v1/movies/import", body.String(), http.Header{"Content-Type": {"application/x-ndjson"}})
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+	var rs struct {
+		Import importReport `json:"import"`
+	}
+	decode(t, b, &rs)
+	if rs.Import.Imported != n {
+		t.Errorf("got %d imported; want %d", rs.Import.Imported, n)
+	}
+
+	movie, err := app.models.Movies.Get(context.Background(), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("Movie %d", n); movie.Title != want {
+		t.Errorf("got title %q; want %q", movie.Title, want)
+	}
+}
+
+func TestImportMoviesTooLarge(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.bulk.maxBytes = 100
+	ts := newTestServer(t, app.routes())
+
+	body := strings.Repeat(`{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`+"\n", 3)
+	code, _, b := ts.do(t, http.MethodPost, "/v1/movies/import", body, http.Header{"Content-Type": {"application/x-ndjson"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("got status %d; want %d", code, http.StatusBadRequest)
+	}
+
+	// The rows before the limit are imported nonetheless.
+	var rs struct {
+		Import importReport `json:"import"`
+	}
+	decode(t, b, &rs)
+	if rs.Import.Imported != 1 || rs.Import.Error != "body must not be larger than 100 bytes" {
+		t.Errorf("got %d imported and error %q", rs.Import.Imported, rs.Import.Error)
+	}
+	if _, err := app.models.Movies.Get(context.Background(), 1); err != nil {
+		t.Errorf("got %v for the imported movie", err)
+	}
+}
+
+// stallingMovieModel fails every InsertBatch() call after the first few with the
+// error of its context, once it has ended.
+type stallingMovieModel struct {
+	data.MovieRepository
+	batches int
+}
+
+func (m *stallingMovieModel) InsertBatch(ctx context.Context, movies []*data.Movie) error {
+	if m.batches == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	m.batches--
+	return m.MovieRepository.InsertBatch(ctx, movies)
+}
+
+func TestImportMoviesTimeout(t *testing.T) {
+	const n = importBatchSize + 2
+	var body strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&body, `{"title": "Movie %d", "year": 2000, "runtime": 90, "genres": ["drama"]}`+"\n", i)
+	}
+	headers := http.Header{"Content-Type": {"application/x-ndjson"}}
+
+	t.Run("After a batch", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.bulk.timeout = 50 * time.Millisecond
+		app.models.Movies = &stallingMovieModel{MovieRepository: app.models.Movies, batches: 1}
+		ts := newTestServer(t, app.routes())
+
+		// The first batch is committed, so the report is sent nonetheless.
+		code, _, b := ts.do(t, http.MethodPost, "/v1/movies/import", body.String(), headers)
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("got status %d; want %d", code, http.StatusServiceUnavailable)
+		}
+		var rs struct {
+			Import importReport `json:"import"`
+		}
+		decode(t, b, &rs)
+		if rs.Import.Total != n || rs.Import.Imported != importBatchSize || rs.Import.Failed != 2 {
+			t.Errorf("got %d rows, %d imported and %d failed", rs.Import.Total, rs.Import.Imported, rs.Import.Failed)
+		}
+		if rs.Import.Error != "the import timed out" {
+			t.Errorf("got error %q; want %q", rs.Import.Error, "the import timed out")
+		}
+		want := importRow{Line: n, Status: importFailed, Title: fmt.Sprintf("Movie %d", n),
+			Errors: map[string]string{"row": "not imported: the import timed out"}}
+		if got := rs.Import.Rows[n-1]; !reflect.DeepEqual(got, want) {
+			t.Errorf("got last row %+v; want %+v", got, want)
+		}
+		if rs.Import.Rows[0].ID != 1 {
+			t.Errorf("got ID %d for the first row; want 1", rs.Import.Rows[0].ID)
+		}
+	})
+
+	t.Run("Before any batch", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.bulk.timeout = 50 * time.Millisecond
+		app.models.Movies = &stallingMovieModel{MovieRepository: app.models.Movies}
+		ts := newTestServer(t, app.routes())
+
+		code, _, _ := ts.do(t, http.MethodPost, "/v1/movies/import", body.String(), headers)
+		if code != http.StatusInternalServerError {
+			t.Fatalf("got status %d; want %d", code, http.StatusInternalServerError)
+		}
+	})
+}
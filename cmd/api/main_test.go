@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenSQLite(t *testing.T) {
+	var cfg config
+	cfg.db.driver = "sqlite"
+	cfg.db.maxOpenConns = 25
+	cfg.db.maxIdleConns = 25
+	cfg.db.maxIdleTime = "15m"
+
+	db, err := openDB(cfg, "file:"+filepath.Join(t.TempDir(), "greenlight.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	var busyTimeout int
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if journalMode != "wal" || busyTimeout != int(sqliteBusyTimeout.Milliseconds()) {
+		t.Errorf("got journal_mode %q and busy_timeout %d; want wal and %d", journalMode, busyTimeout, sqliteBusyTimeout.Milliseconds())
+	}
+
+	// Each connection of the pool would get a database of its own.
+	for _, dsn := range []string{":memory:", "file::memory:", "file:greenlight?mode=memory"} {
+		if db, err := openDB(cfg, dsn); err == nil {
+			db.Close()
+			t.Errorf("got no error for the in-memory DSN %q", dsn)
+		}
+	}
+}
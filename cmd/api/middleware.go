@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// The requestID() middleware makes sure that every request carries an ID. If the client
+// sent a well-formed X-Request-ID header we reuse it, otherwise a new random ID is
+// generated. The ID is stored in the request context and echoed back to the client in
+// the X-Request-ID response header.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = generateRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// The logRequest() middleware writes one access-log entry per request, once the rest
+// of the handler chain has returned, with the response status, the number of bytes
+// written and the time taken to process the request.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		lw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(lw, r)
+
+		properties := map[string]string{
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+			"remote_addr":    r.RemoteAddr,
+			"status":         strconv.Itoa(lw.statusCode),
+			"bytes":          strconv.Itoa(lw.bytes),
+			"latency":        time.Since(start).String(),
+		}
+		if id := app.contextGetRequestID(r); id != "" {
+			properties["request_id"] = id
+		}
+		app.logger.PrintInfo("request completed", properties)
+	})
+}
+
+// Declare the expvar variables used by the metrics() middleware. They are created once at
+// package level, because expvar panics if the same name is published twice.
+var (
+	totalRequestsReceived           = expvar.NewInt("total_requests_received")
+	totalResponsesSent              = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+	totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+)
+
+// The metrics() middleware records the number of requests received, the number of
+// responses sent (in total and by status code) and the cumulative processing time.
+func (app *application) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		totalRequestsReceived.Add(1)
+
+		// Reuse the loggingResponseWriter to capture the status code written by the
+		// rest of the handler chain.
+		mw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		totalResponsesSent.Add(1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
+		totalProcessingTimeMicroseconds.Add(time.Since(start).Microseconds())
+	})
+}
+
+// httpRequestDuration is the Prometheus histogram of request durations, labeled by the
+// httprouter pattern the request matched, the method and the response status code.
+var httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "greenlight",
+	Subsystem: "http",
+	Name:      "request_duration_seconds",
+	Help:      "Duration of HTTP requests by route pattern, method and status code.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route", "method", "status"})
+
+// The instrumentRoute() middleware observes the duration of every request handled by
+// the given route in the httpRequestDuration histogram. It is applied per route in
+// routes(), because that is the only place where the matched pattern is known.
+func (app *application) instrumentRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		mw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		httpRequestDuration.
+			WithLabelValues(pattern, r.Method, strconv.Itoa(mw.statusCode)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// primaryCookie is the name of the cookie holding the time (as a Unix timestamp) until
+// which a client's reads are sent to the primary database after it made a write.
+const primaryCookie = "greenlight_read_primary_until"
+
+// The readYourWrites() middleware decides whether the reads made while handling the
+// request can go to the read replica, or must go to the primary database because the
+// client may otherwise not see its own recent writes. Reads go to the primary when:
+//
+//   - the request is itself a write (e.g. the PUT handler reads the movie and its
+//     version before updating it),
+//   - the client sent an "X-Consistency: strong" header,
+//   - the client made a write less than -db-replica-sticky ago, which we track with a
+//     cookie set on every write request.
+func (app *application) readYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.db.replicaDSN == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		primary := r.Header.Get("X-Consistency") == "strong"
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if cookie, err := r.Cookie(primaryCookie); err == nil {
+				until, err := strconv.ParseInt(cookie.Value, 10, 64)
+				if err == nil && time.Now().Unix() < until {
+					primary = true
+				}
+			}
+		default:
+			primary = true
+			stick := app.config.db.replicaStick
+			http.SetCookie(w, &http.Cookie{
+				Name:     primaryCookie,
+				Value:    strconv.FormatInt(time.Now().Add(stick).Unix(), 10),
+				Path:     "/",
+				MaxAge:   int(stick.Seconds()) + 1,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		if primary {
+			r = r.WithContext(data.UsePrimary(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The enableCORS() middleware adds the CORS headers for requests coming from one of the
+// trusted origins set with the -cors-trusted-origins flag, and answers preflight
+// requests directly.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response differs depending on the Origin and the preflight request
+		// method, so caches must take these headers into account.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			for i := range app.config.cors.trustedOrigins {
+				if origin == app.config.cors.trustedOrigins[i] {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag, Idempotent-Replayed")
+
+					// A preflight request has the OPTIONS method and an
+					// Access-Control-Request-Method header. Reply with the methods and
+					// headers we allow and a 200 OK, without calling the router.
+					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, X-Consistency, X-Expected-Version")
+						w.WriteHeader(http.StatusOK)
+						return
+					}
+					break
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The loggingResponseWriter type wraps an http.ResponseWriter and records the status
+// code and the number of bytes of the response body written through it.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	bytes       int
+	wroteHeader bool
+}
+
+func (lw *loggingResponseWriter) WriteHeader(statusCode int) {
+	if !lw.wroteHeader {
+		lw.statusCode = statusCode
+		lw.wroteHeader = true
+	}
+	lw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	lw.wroteHeader = true
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController
+// can reach optional interfaces such as http.Flusher.
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// generateRequestID returns a random 128-bit ID encoded as a hex string.
+func generateRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// crypto/rand should never fail on the platforms we run on, but fall back to a
+		// time-based ID rather than leaving the request without one.
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to reuse. We only
+// accept short IDs made of letters, digits, '-' and '_', so that the value can't be
+// used to inject content into our logs or response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
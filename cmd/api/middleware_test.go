@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	// A well-formed request ID sent by the client is echoed back and included in
+	// error responses.
+	code, headers, body := ts.do(t, http.MethodGet, "/v1/movies/1", "", http.Header{"X-Request-Id": {"abc-123"}})
+	if code != http.StatusNotFound {
+		t.Fatalf("got status %d; want %d", code, http.StatusNotFound)
+	}
+	if got := headers.Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("got X-Request-ID %q; want %q", got, "abc-123")
+	}
+
+	var rs struct {
+		RequestID string `json:"request_id"`
+	}
+	decode(t, body, &rs)
+	if rs.RequestID != "abc-123" {
+		t.Errorf("got request_id %q in the error response; want %q", rs.RequestID, "abc-123")
+	}
+
+	// Anything else is replaced with a generated ID.
+	_, headers, _ = ts.do(t, http.MethodGet, "/v1/healthcheck", "", http.Header{"X-Request-Id": {"bad id!"}})
+	if got := headers.Get("X-Request-ID"); len(got) != 32 {
+		t.Errorf("got X-Request-ID %q; want a generated 32 character ID", got)
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.cors.trustedOrigins = []string{"https://www.example.com"}
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name        string
+		origin      string
+		wantOrigin  string
+		wantMethods bool
+	}{
+		{"Trusted origin", "https://www.example.com", "https://www.example.com", true},
+		{"Untrusted origin", "https://evil.example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.do(t, http.MethodOptions, "/v1/movies/1", "", http.Header{
+				"Origin":                        {tt.origin},
+				"Access-Control-Request-Method": {http.MethodPatch},
+			})
+
+			if got := headers.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q; want %q", got, tt.wantOrigin)
+			}
+			if got := headers.Get("Access-Control-Allow-Methods") != ""; got != tt.wantMethods {
+				t.Errorf("got Access-Control-Allow-Methods %q", headers.Get("Access-Control-Allow-Methods"))
+			}
+			if tt.wantMethods && code != http.StatusOK {
+				t.Errorf("got status %d for the preflight request; want %d", code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.db.replicaDSN = "replica"
+	app.config.db.replicaStick = 5 * time.Second
+	ts := newTestServer(t, app.routes())
+
+	// Reads don't pin the client to the primary.
+	_, headers, _ := ts.do(t, http.MethodGet, "/v1/movies", "", nil)
+	if got := headers.Get("Set-Cookie"); got != "" {
+		t.Errorf("got Set-Cookie %q for a read; want none", got)
+	}
+
+	// Writes do, for the sticky window.
+	_, headers, _ = ts.do(t, http.MethodPost, "/v1/movies", `{"title":"Moana","year":2016,"runtime":107,"genres":["animation"]}`, nil)
+	if got := headers.Get("Set-Cookie"); !strings.HasPrefix(got, primaryCookie+"=") {
+		t.Errorf("got Set-Cookie %q for a write; want the %s cookie", got, primaryCookie)
+	}
+}
+
+func TestMetricsRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	api := newTestServer(t, app.routes())
+	metrics := newTestServer(t, app.metricsRoutes())
+
+	// The metrics are only served by the -metrics-addr listener.
+	for _, urlPath := range []string{"/debug/vars", "/metrics"} {
+		if code, _, _ := api.do(t, http.MethodGet, urlPath, "", nil); code != http.StatusNotFound {
+			t.Errorf("got status %d for %s on the API; want %d", code, urlPath, http.StatusNotFound)
+		}
+		if code, _, _ := metrics.do(t, http.MethodGet, urlPath, "", nil); code != http.StatusOK {
+			t.Errorf("got status %d for %s on the metrics listener; want %d", code, urlPath, http.StatusOK)
+		}
+	}
+}
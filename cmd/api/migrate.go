@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+	"github.com/shynggys9219/greenlight/internal/jsonlog"
+	"github.com/shynggys9219/greenlight/migrations"
+)
+
+// migrateUsage describes the arguments accepted by the migrate subcommand.
+const migrateUsage = "usage: api [flags] migrate up|down|status|create NAME"
+
+// gooseDialects maps the -db-driver values to the goose SQL dialects.
+var gooseDialects = map[string]string{
+	"postgres": "postgres",
+	"sqlite":   "sqlite3",
+}
+
+// runMigrate implements the migrate subcommand. The up, down and status commands work
+// on the migrations embedded in the binary, while create writes a new migration file
+// to the migrations directory on disk, so it must be run from the project root.
+func runMigrate(cfg config, logger *jsonlog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		goose.SetLogger(gooseLogger{logger})
+		goose.SetSequential(true)
+		dir := filepath.Join("migrations", migrations.Dir(cfg.db.driver))
+		return goose.Create(nil, dir, args[1], "sql")
+	}
+
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDB(cfg, cfg.db.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return migrate(db, cfg.db.driver, logger, args[0])
+}
+
+// migrate runs a goose command (up, down or status) against the database using the
+// embedded migrations for the given driver.
+func migrate(db *sql.DB, driver string, logger *jsonlog.Logger, command string) error {
+	goose.SetBaseFS(migrations.FS)
+	goose.SetLogger(gooseLogger{logger})
+
+	err := goose.SetDialect(gooseDialects[driver])
+	if err != nil {
+		return err
+	}
+
+	dir := migrations.Dir(driver)
+	switch command {
+	case "up":
+		return goose.Up(db, dir)
+	case "down":
+		return goose.Down(db, dir)
+	case "status":
+		return goose.Status(db, dir)
+	default:
+		return fmt.Errorf("unknown migrate command %q; %s", command, migrateUsage)
+	}
+}
+
+// gooseLogger adapts a jsonlog.Logger to the goose.Logger interface, so that the
+// output of the migrations is logged as JSON like the rest of the application.
+type gooseLogger struct {
+	logger *jsonlog.Logger
+}
+
+func (l gooseLogger) Fatal(v ...any) {
+	l.logger.PrintFatal(errors.New(fmt.Sprint(v...)), nil)
+}
+
+func (l gooseLogger) Fatalf(format string, v ...any) {
+	l.logger.PrintFatal(fmt.Errorf(format, v...), nil)
+}
+
+func (l gooseLogger) Print(v ...any) {
+	l.logger.PrintInfo(strings.TrimSpace(fmt.Sprint(v...)), nil)
+}
+
+func (l gooseLogger) Println(v ...any) {
+	l.logger.PrintInfo(strings.TrimSpace(fmt.Sprint(v...)), nil)
+}
+
+func (l gooseLogger) Printf(format string, v ...any) {
+	l.logger.PrintInfo(strings.TrimSpace(fmt.Sprintf(format, v...)), nil)
+}
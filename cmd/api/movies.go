@@ -1,23 +1,54 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/shynggys9219/greenlight/internal/data"
 	"gopkg.in/go-playground/validator.v9"
+	"io"
 	"net/http"
+	"reflect"
+	"time"
 )
 
+// movieInput holds the fields of a movie which clients can change, and the rules they
+// must follow. The year and genres rules match the check constraints of the movies
+// table.
+type movieInput struct {
+	Title   string   `json:"title" validate:"required,max=500"`
+	Year    int32    `json:"year" validate:"required,gte=1888,notfuture"`
+	Runtime int32    `json:"runtime" validate:"required,gt=0"`
+	Genres  []string `json:"genres" validate:"required,min=1,max=5,unique,dive,required"`
+}
+
+// actorInput holds the fields of an actor which clients can change.
+type actorInput struct {
+	Fullname   string   `json:"fullname" validate:"required,max=500"`
+	Year       int32    `json:"year" validate:"required,notfuture"`
+	Films      []string `json:"films" validate:"unique,dive,required"`
+	Girlfriend string   `json:"girlfriend"`
+}
+
+// directorInput holds the fields of a director which clients can change.
+type directorInput struct {
+	Name    string   `json:"name" validate:"required,max=500"`
+	Surname string   `json:"surname" validate:"required,max=500"`
+	Awords  []string `json:"awords" validate:"unique,dive,required"`
+}
+
 func (app *application) createActorHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Fullname   string   `json:"fullname"`
-		Year       int32    `json:"year"`
-		Films      []string `json:"films"`
-		Girlfriend string   `json:"girlfriend"`
-	}
+	var input actorInput
 	err := app.readJSON(w, r, &input) //non-nil pointer as the target decode destination
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
 	}
 	actor := &data.Actor{
 		Fullname:   input.Fullname,
@@ -25,35 +56,36 @@ func (app *application) createActorHandler(w http.ResponseWriter, r *http.Reques
 		Films:      input.Films,
 		Girlfriend: input.Girlfriend,
 	}
-	err = app.models.Actor.INSERTACTOR(actor)
+	err = app.modelsFor(r).Actor.INSERTACTOR(r.Context(), actor)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", actor.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/actor/%d", actor.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"actor": actor}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"actor": actor}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 func (app *application) createDirectorHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name    string   `json:"name"`
-		Surname string   `json:"surname"`
-		Awords  []string `json:"awords"`
-	}
+	var input directorInput
 	err := app.readJSON(w, r, &input) //non-nil pointer as the target decode destination
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
 	}
 	directors := &data.Directors{
 		Name:    input.Name,
 		Surname: input.Surname,
 		Awords:  input.Awords,
 	}
-	err = app.models.Directors.InsertDirector(directors)
+	err = app.modelsFor(r).Directors.InsertDirector(r.Context(), directors)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -61,7 +93,7 @@ func (app *application) createDirectorHandler(w http.ResponseWriter, r *http.Req
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/directors/%d", directors.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"director": directors}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"director": directors}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -72,7 +104,7 @@ func (app *application) showActorHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
-	actor, err := app.models.Actor.GetActors(id)
+	actor, err := app.modelsFor(r).Actor.GetActors(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -82,21 +114,21 @@ func (app *application) showActorHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"actor": actor}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"actor": actor}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title   string   `json:"title"`
-		Year    int32    `json:"year"`
-		Runtime int32    `json:"runtime"`
-		Genres  []string `json:"genres"`
-	}
+	var input movieInput
 	err := app.readJSON(w, r, &input) //non-nil pointer as the target decode destination
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
 	}
 	movie := &data.Movie{
 		Title:   input.Title,
@@ -104,7 +136,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Runtime: input.Runtime,
 		Genres:  input.Genres,
 	}
-	err = app.models.Movies.Insert(movie)
+	err = app.modelsFor(r).Movies.Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -112,7 +144,147 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The importMoviesHandler() handles POST /v1/movies/import, which adds the movies in a
+// CSV or NDJSON body (see newMovieRowReader). Each row is validated like the body of
+// POST /v1/movies, against the rules of movieInput, and the valid ones are inserted in
+// batches of importBatchSize. The response reports the outcome of every row, so a few
+// invalid rows don't prevent the others from being imported. A retried import doesn't
+// import the rows twice if it carries an Idempotency-Key header (see idempotentBulk()).
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := app.newMovieRowReader(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedImport):
+			app.unsupportedMediaTypeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// Importing tens of thousands of movies takes longer than the server timeouts, and
+	// each batch longer than the query timeout, so the import gets -bulk-timeout.
+	app.extendDeadlines(w)
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.bulk.timeout)
+	defer cancel()
+
+	report := importReport{Rows: []importRow{}}
+	var batch []*data.Movie
+	var batchRows []int // the index in report.Rows of each movie in batch
+
+	// notImported marks the rows of the batch from the i-th movie on as failed, when
+	// ctx ends before they could be inserted.
+	notImported := func(i int, err error) {
+		for _, row := range batchRows[i:] {
+			report.Rows[row].Status = importFailed
+			report.Rows[row].Errors = map[string]string{"row": "not imported: " + importStopped(err)}
+		}
+	}
+
+	// insertBatch inserts the pending batch. If that fails, the movies are inserted
+	// one by one instead, so that a single row rejected by the database doesn't fail
+	// the rest of the batch. It only returns an error when ctx has ended, after
+	// marking the movies it couldn't insert.
+	insertBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := app.modelsFor(r).Movies.InsertBatch(ctx, batch)
+		for i, movie := range batch {
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					notImported(i, ctxErr)
+					return ctxErr
+				}
+				if rowErr := app.modelsFor(r).Movies.Insert(ctx, movie); rowErr != nil {
+					if ctxErr := ctx.Err(); ctxErr != nil {
+						notImported(i, ctxErr)
+						return ctxErr
+					}
+					app.logger.PrintError(rowErr, map[string]string{"request_url": r.URL.String(), "title": movie.Title})
+					report.Rows[batchRows[i]].Status = importFailed
+					report.Rows[batchRows[i]].Errors = map[string]string{"row": "could not be saved"}
+					continue
+				}
+			}
+			report.Rows[batchRows[i]].ID = movie.ID
+		}
+		batch, batchRows = batch[:0], batchRows[:0]
+		return nil
+	}
+
+	var insertErr error
+	for {
+		line, input, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			report.Rows = append(report.Rows, importRow{Line: line, Status: importFailed, Title: input.Title, Errors: rowErr.errs})
+			continue
+		}
+		if err != nil {
+			// The rows read so far are still imported, and reported.
+			report.Error = err.Error()
+			break
+		}
+
+		if errs := validationErrors(input); errs != nil {
+			report.Rows = append(report.Rows, importRow{Line: line, Status: importFailed, Title: input.Title, Errors: errs})
+			continue
+		}
+
+		report.Rows = append(report.Rows, importRow{Line: line, Status: importImported, Title: input.Title})
+		batch = append(batch, &data.Movie{Title: input.Title, Year: input.Year, Runtime: input.Runtime, Genres: input.Genres})
+		batchRows = append(batchRows, len(report.Rows)-1)
+		if len(batch) == importBatchSize {
+			insertErr = insertBatch()
+			if insertErr != nil {
+				break
+			}
+		}
+	}
+	if insertErr == nil {
+		insertErr = insertBatch()
+	}
+
+	report.Total = len(report.Rows)
+	for _, row := range report.Rows {
+		if row.Status == importImported {
+			report.Imported++
+		}
+	}
+	report.Failed = report.Total - report.Imported
+
+	if insertErr != nil {
+		// If nothing was saved, the import failed as a whole. Otherwise the earlier
+		// batches are committed, and the client needs the report to know which rows
+		// to send again.
+		if report.Imported == 0 {
+			app.serverErrorResponse(w, r, insertErr)
+			return
+		}
+		app.logError(r, insertErr)
+		report.Error = importStopped(insertErr)
+		err = app.writeResponse(w, r, http.StatusServiceUnavailable, envelope{"import": report}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if report.Error != "" {
+		status = http.StatusBadRequest
+	}
+	err = app.writeResponse(w, r, status, envelope{"import": report}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -125,7 +297,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Call the Get() method to fetch the data for a specific movie. We also need to // use the errors.Is() function to check if it returns a data.ErrRecordNotFound // error, in which case we send a 404 Not Found response to the client.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.modelsFor(r).Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -135,7 +307,12 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Let clients holding the current version of the movie revalidate their copy
+	// without downloading it again.
+	if app.notModified(w, r, movieETag(movie), movie.UpdatedAt) {
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -159,7 +336,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 //		}
 //		return
 //	}
-//	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+//	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 //	if err != nil {
 //		app.serverErrorResponse(w, r, err)
 //	}
@@ -171,7 +348,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.Movies.Delete(id)
+	err = app.modelsFor(r).Movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -181,7 +358,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -192,7 +369,7 @@ func (app *application) deleteActorHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.Actor.DeleteActor(id)
+	err = app.modelsFor(r).Actor.DeleteActor(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -202,7 +379,7 @@ func (app *application) deleteActorHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "actor successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "actor successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -214,7 +391,8 @@ func (app *application) updateActorHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	actor, err := app.models.Actor.GetActors(id)
+	// Read the current version from the primary, like updateMovieHandler() does.
+	actor, err := app.modelsFor(r).Actor.GetActors(data.UsePrimary(r.Context()), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -224,31 +402,33 @@ func (app *application) updateActorHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	var input struct {
-		Fullname   string   `json:"fullname"`
-		Year       int32    `json:"year"`
-		Films      []string `json:"films"`
-		Girlfriend string   `json:"girlfriend"`
-	}
+	var input actorInput
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
 	actor.Fullname = input.Fullname
 	actor.Year = input.Year
 	actor.Films = input.Films
 	actor.Girlfriend = input.Girlfriend
 
-	err = app.models.Actor.UpdateActor(actor)
-	fmt.Println("INPUT", actor)
-
+	err = app.modelsFor(r).Actor.UpdateActor(r.Context(), actor)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"actor": actor}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"actor": actor}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -262,7 +442,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	// Read the current version from the primary, bypassing the cache and the replica,
+	// so that the If-Match precondition and the update start from the latest one.
+	movie, err := app.modelsFor(r).Movies.Get(data.UsePrimary(r.Context()), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -272,44 +454,91 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-
-	var input struct {
-		Title   string   `json:"title"`
-		Year    int32    `json:"year"`
-		Runtime int32    `json:"runtime"`
-		Genres  []string `json:"genres"`
+	if !app.checkPrecondition(w, r, movie) {
+		return
 	}
 
+	var input movieInput
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
 
 	movie.Title = input.Title
 	movie.Year = input.Year
 	movie.Runtime = input.Runtime
 	movie.Genres = input.Genres
 
-	err = app.models.Movies.Update(movie)
+	// Update() still fails with ErrEditConflict if the movie was updated by another
+	// request since we read it above.
+	err = app.modelsFor(r).Movies.Update(r.Context(), movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", representationETag(r, movieETag(movie)))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// The updateMovieeHandler() applies a JSON Merge Patch or a JSON Patch (see readPatch)
+// to a movie, e.g. {"year": 2017} or [{"op": "add", "path": "/genres/-", "value":
+// "drama"}]. The patched movie is validated before it is saved.
 func (app *application) updateMovieeHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+	// Read the current version from the primary, like updateMovieHandler() does.
+	movie, err := app.modelsFor(r).Movies.Get(data.UsePrimary(r.Context()), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.checkPrecondition(w, r, movie) {
+		return
+	}
+
+	// The patch is applied to the fields clients can change, so the id and version
+	// can't be patched.
+	input := movieInput{Title: movie.Title, Year: movie.Year, Runtime: movie.Runtime, Genres: movie.Genres}
+	err = app.readPatch(w, r, &input)
+	if err != nil {
+		app.patchErrorResponse(w, r, err)
+		return
+	}
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+
+	err = app.modelsFor(r).Movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -318,45 +547,141 @@ func (app *application) updateMovieeHandler(w http.ResponseWriter, r *http.Reque
 			app.serverErrorResponse(w, r, err)
 		}
 		return
-		return
 	}
-	// Use pointers for the Title, Year and Runtime fields.
-	var input struct {
-		Title   *string  `json:"title"`   // This will be nil if there is no corresponding key in the JSON.
-		Year    *int32   `json:"year"`    // Likewise...
-		Runtime *int32   `json:"runtime"` // Likewise...
-		Genres  []string `json:"genres"`  // We don't need to change this because slices already have the zero-value nil.
+
+	headers := make(http.Header)
+	headers.Set("ETag", representationETag(r, movieETag(movie)))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
-	// Decode the JSON as normal.
-	err = app.readJSON(w, r, &input)
+}
+
+// The patchActorHandler() applies a JSON Merge Patch or a JSON Patch to an actor.
+func (app *application) patchActorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Read the current version from the primary, like updateMovieHandler() does.
+	actor, err := app.modelsFor(r).Actor.GetActors(data.UsePrimary(r.Context()), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
+	input := actorInput{Fullname: actor.Fullname, Year: actor.Year, Films: actor.Films, Girlfriend: actor.Girlfriend}
+	err = app.readPatch(w, r, &input)
+	if err != nil {
+		app.patchErrorResponse(w, r, err)
+		return
 	}
-	if input.Year != nil {
-		movie.Year = *input.Year
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
 	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+
+	actor.Fullname = input.Fullname
+	actor.Year = input.Year
+	actor.Films = input.Films
+	actor.Girlfriend = input.Girlfriend
+
+	err = app.modelsFor(r).Actor.UpdateActor(r.Context(), actor)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres // Note that we don't need to dereference a slice.
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"actor": actor}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
+}
 
-	err = app.models.Movies.Update(movie)
+func (app *application) showDirectorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	director, err := app.modelsFor(r).Directors.GetDirector(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"director": director}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The patchDirectorHandler() applies a JSON Merge Patch or a JSON Patch to a director.
+func (app *application) patchDirectorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Read the current version from the primary, like updateMovieHandler() does.
+	director, err := app.modelsFor(r).Directors.GetDirector(data.UsePrimary(r.Context()), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	input := directorInput{Name: director.Name, Surname: director.Surname, Awords: director.Awords}
+	err = app.readPatch(w, r, &input)
+	if err != nil {
+		app.patchErrorResponse(w, r, err)
+		return
+	}
+	if errs := validationErrors(input); errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	director.Name = input.Name
+	director.Surname = input.Surname
+	director.Awords = input.Awords
+
+	err = app.modelsFor(r).Directors.UpdateDirector(r.Context(), director)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"director": director}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Title  string
@@ -373,18 +698,154 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 
 	// Call the GetAll() method to retrieve the movies, passing in the various filter // parameters.
-	movies, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, err := app.modelsFor(r).Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The list was last modified when the most recently updated movie in it was.
+	env := envelope{"movies": movies}
+	etag, err := weakETag(env)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	var lastModified time.Time
+	for _, movie := range movies {
+		if movie.UpdatedAt.After(lastModified) {
+			lastModified = movie.UpdatedAt
+		}
+	}
+	if app.notModified(w, r, etag, lastModified) {
+		return
+	}
+
 	// Send a JSON response containing the movie data.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// exportFlushEvery is the number of movies exportMoviesHandler() writes between two
+// flushes of the response.
+const exportFlushEvery = 100
+
+// The exportMoviesHandler() handles GET /v1/movies/export, which sends every movie
+// matching the title, genres and sort parameters of GET /v1/movies, as NDJSON (one
+// movie per line, the default) or CSV depending on the format parameter. The movies
+// are streamed from the database to the client, and flushed as they go, so that the
+// export never has to fit in memory.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Genres []string
+		Format string
+		data.Filters
+	}
+	qs := r.URL.Query()
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Format = app.readString(qs, "format", "ndjson")
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	errs := make(map[string]string)
+	if input.Format != "ndjson" && input.Format != "csv" {
+		errs["format"] = "must be ndjson or csv"
+	}
+	if !contains(input.Filters.SortSafelist, input.Filters.Sort) {
+		errs["sort"] = "invalid sort value"
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	// The response is only started with the first movie, so that an error before it
+	// can still be sent as an error response.
+	started := false
+	var writeMovie func(movie *data.Movie) error
+	var cw *csv.Writer
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "movies."+input.Format))
+		switch input.Format {
+		case "csv":
+			w.Header().Set("Content-Type", mediaCSV)
+			w.WriteHeader(http.StatusOK)
+			cw = csv.NewWriter(w)
+			columns := csvHeader(reflect.TypeOf(data.Movie{}))
+			writeMovie = func(movie *data.Movie) error {
+				row, err := csvRecord(movie, columns)
+				if err != nil {
+					return err
+				}
+				return cw.Write(row)
+			}
+			return cw.Write(columns)
+		default:
+			w.Header().Set("Content-Type", mediaNDJSON)
+			w.WriteHeader(http.StatusOK)
+			enc := json.NewEncoder(w)
+			writeMovie = func(movie *data.Movie) error {
+				return enc.Encode(movie)
+			}
+			return nil
+		}
+	}
+	flush := func() error {
+		if cw != nil {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+		}
+		return http.NewResponseController(w).Flush()
+	}
+
+	// A large export takes longer than the write timeout of the server.
+	app.extendDeadlines(w)
+
+	count := 0
+	err := app.modelsFor(r).Movies.Export(r.Context(), input.Title, input.Genres, input.Filters, func(movie *data.Movie) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		err := writeMovie(movie)
+		if err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// The status has been sent already. Abort the response, so that the client
+		// knows that the export is incomplete rather than taking it for the whole
+		// catalog.
+		if r.Context().Err() == nil {
+			app.logError(r, err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
 func (app *application) listDirectorsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name    string
@@ -403,13 +864,25 @@ func (app *application) listDirectorsHandler(w http.ResponseWriter, r *http.Requ
 	input.Filters.SortSafelist = []string{"id", "name", "surname", "-id", "-name", "-awords", "awords", "-surname", "-runtime"}
 
 	// Call the GetAll() method to retrieve the movies, passing in the various filter // parameters.
-	directors, err := app.models.Directors.GetAllDirectors(input.Name, input.Surname, input.Awords, input.Filters)
+	directors, err := app.modelsFor(r).Directors.GetAllDirectors(r.Context(), input.Name, input.Surname, input.Awords, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+
+	// Directors have no timestamps, so the list is only revalidated with its ETag.
+	env := envelope{"director": directors}
+	etag, err := weakETag(env)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, etag, time.Time{}) {
+		return
+	}
+
 	// Send a JSON response containing the movie data.
-	err = app.writeJSON(w, http.StatusOK, envelope{"director": directors}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
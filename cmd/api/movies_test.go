@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// seedMovies inserts the given movies into the application's movie repository.
+func seedMovies(t *testing.T, app *application, movies ...*data.Movie) {
+	t.Helper()
+
+	for _, movie := range movies {
+		err := app.models.Movies.Insert(context.Background(), movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateMovie(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	code, headers, body := ts.do(t, http.MethodPost, "/v1/movies",
+		`{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation", "adventure"]}`, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", code, http.StatusCreated)
+	}
+	if got := headers.Get("Location"); got != "/v1/movies/1" {
+		t.Errorf("got Location %q; want %q", got, "/v1/movies/1")
+	}
+
+	var rs struct {
+		Movie data.Movie `json:"movie"`
+	}
+	decode(t, body, &rs)
+	want := data.Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}, Version: 1}
+	if !reflect.DeepEqual(rs.Movie, want) {
+		t.Errorf("got movie %+v; want %+v", rs.Movie, want)
+	}
+
+	// The body is validated like the rows of an import.
+	code, _, _ = ts.do(t, http.MethodPost, "/v1/movies", `{"title": "", "year": 2016, "runtime": 107, "genres": ["animation"]}`, nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an invalid movie; want %d", code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestShowMovie(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Valid ID", "/v1/movies/1", http.StatusOK},
+		{"Non-existent ID", "/v1/movies/2", http.StatusNotFound},
+		{"Negative ID", "/v1/movies/-1", http.StatusNotFound},
+		{"Decimal ID", "/v1/movies/1.23", http.StatusNotFound},
+		{"String ID", "/v1/movies/foo", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, tt.urlPath, "", nil)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d", code, tt.wantCode)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var rs struct {
+				Movie data.Movie `json:"movie"`
+			}
+			decode(t, body, &rs)
+			if rs.Movie.Title != "Moana" {
+				t.Errorf("got title %q; want %q", rs.Movie.Title, "Moana")
+			}
+		})
+	}
+}
+
+func TestUpdateMovie(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	code, _, body := ts.do(t, http.MethodPut, "/v1/movies/1",
+		`{"title": "Black Panther", "year": 2018, "runtime": 134, "genres": ["action"]}`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+
+	var rs struct {
+		Movie data.Movie `json:"movie"`
+	}
+	decode(t, body, &rs)
+	want := data.Movie{ID: 1, Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action"}, Version: 2}
+	if !reflect.DeepEqual(rs.Movie, want) {
+		t.Errorf("got movie %+v; want %+v", rs.Movie, want)
+	}
+
+	code, _, _ = ts.do(t, http.MethodPut, "/v1/movies/2", `{"title": "Up"}`, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("got status %d for a missing movie; want %d", code, http.StatusNotFound)
+	}
+
+	code, _, _ = ts.do(t, http.MethodPut, "/v1/movies/1", `{"title": "Black Panther"}`, nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an invalid movie; want %d", code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestPartialUpdateMovie(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	code, _, body := ts.do(t, http.MethodPatch, "/v1/movies/1", `{"year": 2017}`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+
+	var rs struct {
+		Movie data.Movie `json:"movie"`
+	}
+	decode(t, body, &rs)
+	want := data.Movie{ID: 1, Title: "Moana", Year: 2017, Runtime: 107, Genres: []string{"animation"}, Version: 2}
+	if !reflect.DeepEqual(rs.Movie, want) {
+		t.Errorf("got movie %+v; want %+v", rs.Movie, want)
+	}
+}
+
+func TestDeleteMovie(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	code, _, _ := ts.do(t, http.MethodDelete, "/v1/movies/1", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _, _ = ts.do(t, http.MethodDelete, "/v1/movies/1", "", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("got status %d for a deleted movie; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestListMovies(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app,
+		&data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		&data.Movie{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action", "adventure"}},
+		&data.Movie{Title: "Deadpool", Year: 2016, Runtime: 108, Genres: []string{"action", "comedy"}},
+		&data.Movie{Title: "The Breakfast Club", Year: 1986, Runtime: 96, Genres: []string{"drama"}},
+	)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name       string
+		query      string
+		wantTitles []string
+	}{
+		{"Default", "", []string{"Moana", "Black Panther", "Deadpool", "The Breakfast Club"}},
+		{"Title search", "?title=breakfast+club", []string{"The Breakfast Club"}},
+		{"Genres", "?genres=action,adventure", []string{"Black Panther"}},
+		{"Sort by title", "?sort=title", []string{"Black Panther", "Deadpool", "Moana", "The Breakfast Club"}},
+		{"Sort by year descending", "?sort=-year", []string{"Black Panther", "Moana", "Deadpool", "The Breakfast Club"}},
+		{"Paging", "?sort=id&page=2&page_size=3", []string{"The Breakfast Club"}},
+		{"Out of range page", "?page=3&page_size=3", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, "/v1/movies"+tt.query, "", nil)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d; want %d", code, http.StatusOK)
+			}
+
+			var rs struct {
+				Movies []data.Movie `json:"movies"`
+			}
+			decode(t, body, &rs)
+
+			titles := []string{}
+			for _, movie := range rs.Movies {
+				titles = append(titles, movie.Title)
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Errorf("got titles %q; want %q", titles, tt.wantTitles)
+			}
+		})
+	}
+}
+
+func TestActors(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	code, headers, body := ts.do(t, http.MethodPost, "/v1/actor",
+		`{"fullname": "Dwayne Johnson", "year": 1972, "films": ["Moana"], "girlfriend": "Lauren"}`, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("create: got status %d; want %d", code, http.StatusCreated)
+	}
+	if got := headers.Get("Location"); got != "/v1/actor/1" {
+		t.Errorf("create: got Location %q; want %q", got, "/v1/actor/1")
+	}
+
+	code, _, _ = ts.do(t, http.MethodPost, "/v1/actor", `{"fullname": "", "year": 1972}`, nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("create invalid: got status %d; want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	var rs struct {
+		Actor data.Actor `json:"actor"`
+	}
+	decode(t, body, &rs)
+	if rs.Actor.ID != 1 {
+		t.Fatalf("create: got id %d; want 1", rs.Actor.ID)
+	}
+
+	code, _, body = ts.do(t, http.MethodPut, "/v1/actor/1",
+		`{"fullname": "Dwayne Johnson", "year": 1972, "films": ["Moana", "Jumanji"], "girlfriend": "Lauren"}`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("update: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _, _ = ts.do(t, http.MethodPut, "/v1/actor/1", `{"fullname": "Dwayne Johnson", "year": 1972, "films": ["Moana", "Moana"]}`, nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("update invalid: got status %d; want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	code, _, body = ts.do(t, http.MethodGet, "/v1/actor/1", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("show: got status %d; want %d", code, http.StatusOK)
+	}
+	rs.Actor = data.Actor{}
+	decode(t, body, &rs)
+	if want := []string{"Moana", "Jumanji"}; !reflect.DeepEqual(rs.Actor.Films, want) {
+		t.Errorf("show: got films %q; want %q", rs.Actor.Films, want)
+	}
+
+	code, _, _ = ts.do(t, http.MethodDelete, "/v1/actor/1", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _, _ = ts.do(t, http.MethodGet, "/v1/actor/1", "", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("show deleted: got status %d; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestDirectors(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	for _, body := range []string{
+		`{"name": "Ryan", "surname": "Coogler", "awords": ["Saturn Award"]}`,
+		`{"name": "John", "surname": "Hughes", "awords": []}`,
+		`{"name": "Ron", "surname": "Clements", "awords": ["Annie Award", "Saturn Award"]}`,
+	} {
+		code, _, _ := ts.do(t, http.MethodPost, "/v1/directors", body, nil)
+		if code != http.StatusCreated {
+			t.Fatalf("create: got status %d; want %d", code, http.StatusCreated)
+		}
+	}
+
+	code, _, _ := ts.do(t, http.MethodPost, "/v1/directors", `{"name": "Ryan"}`, nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("create invalid: got status %d; want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	code, _, body := ts.do(t, http.MethodGet, "/v1/directors?awords=Saturn+Award&sort=-surname", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("list: got status %d; want %d", code, http.StatusOK)
+	}
+
+	var rs struct {
+		Directors []data.Directors `json:"director"`
+	}
+	decode(t, body, &rs)
+
+	surnames := []string{}
+	for _, director := range rs.Directors {
+		surnames = append(surnames, director.Surname)
+	}
+	if want := []string{"Coogler", "Clements"}; !reflect.DeepEqual(surnames, want) {
+		t.Errorf("list: got surnames %q; want %q", surnames, want)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The media types writeResponse() can encode a response in.
+const (
+	mediaJSON    = "application/json"
+	mediaXML     = "application/xml"
+	mediaCSV     = "text/csv"
+	mediaMsgPack = "application/msgpack"
+)
+
+// mediaAliases maps the other names clients use for our media types to the names above.
+// Clients opting into RFC 7807 errors (see wantsProblem) may accept nothing but
+// application/problem+json, so it stands for JSON too: the successful responses are
+// plain JSON documents, and the errors, which don't go through negotiate(), problem
+// documents.
+var mediaAliases = map[string]string{
+	"application/problem+json": mediaJSON,
+	"text/xml":                 mediaXML,
+	"application/x-msgpack":    mediaMsgPack,
+	"application/vnd.msgpack":  mediaMsgPack,
+}
+
+// The writeResponse() helper sends data in the format the client prefers according to
+// its Accept header: JSON (the default), XML, MessagePack, or CSV for the responses
+// holding a list of records, such as GET /v1/movies. If none of them is acceptable, a
+// 406 Not Acceptable response is sent instead.
+//
+// Every format uses the same field names as the JSON one.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	offers := []string{mediaJSON, mediaXML, mediaMsgPack}
+	if _, ok := csvList(data); ok {
+		offers = append(offers, mediaCSV)
+	}
+
+	addVary(w.Header(), "Accept")
+	mediaType := negotiate(r.Header.Get("Accept"), offers)
+
+	var buf bytes.Buffer
+	var err error
+	switch mediaType {
+	case mediaJSON:
+		return app.writeJSON(w, status, data, headers)
+	case mediaXML:
+		err = encodeXML(&buf, data)
+	case mediaMsgPack:
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		err = enc.Encode(data)
+	case mediaCSV:
+		err = encodeCSV(&buf, data)
+	default:
+		app.notAcceptableResponse(w, r, offers)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// addVary adds field to the Vary header, unless it is already listed.
+func addVary(h http.Header, field string) {
+	for _, value := range h.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// negotiate returns the offer the Accept header value prefers, or "" if none of the
+// offers is acceptable. The offers are listed in the server's order of preference,
+// which breaks the ties. An empty Accept header accepts anything.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The quality of an offer is that of the most specific media range matching
+		// it: type/subtype, then type/*, then */*.
+		q, specificity := 0.0, -1
+		for _, accepted := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(accepted)
+			if err != nil {
+				continue
+			}
+			if alias, ok := mediaAliases[mediaType]; ok {
+				mediaType = alias
+			}
+
+			s := -1
+			switch {
+			case mediaType == offer:
+				s = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+				s = 1
+			case mediaType == "*/*":
+				s = 0
+			}
+			if s <= specificity {
+				continue
+			}
+
+			specificity, q = s, 1.0
+			if v, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// encodeXML writes data as an XML document. The JSON encoding of data is converted
+// token by token, so that the elements keep the names and order of the JSON keys.
+// Objects become elements holding one child per key, and arrays elements holding one
+// <item> child per value:
+//
+//	<response><movies><item><id>1</id><genres><item>animation</item></genres>...
+//
+// Keys which aren't valid XML names, like the "genres[0]" of a validation error, are
+// written as <entry key="genres[0]"> instead.
+func encodeXML(w io.Writer, data any) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	enc := xml.NewEncoder(w)
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	err = encodeXMLValue(dec, enc, xml.StartElement{Name: xml.Name{Local: "response"}})
+	if err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// encodeXMLValue reads the next JSON value from dec and writes it as the element start.
+func encodeXMLValue(dec *json.Decoder, enc *xml.Encoder, start xml.StartElement) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	err = enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		for dec.More() {
+			child := xml.StartElement{Name: xml.Name{Local: "item"}}
+			if tok == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = xmlElement(key.(string))
+			}
+			err = encodeXMLValue(dec, enc, child)
+			if err != nil {
+				return err
+			}
+		}
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		if err != nil {
+			return err
+		}
+	case nil:
+		// null is an empty element.
+	default:
+		err = enc.EncodeToken(xml.CharData(fmt.Sprint(tok)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// xmlElement returns the element the JSON object key is written as: an element named
+// key if key is a valid XML name, and an <entry> element carrying key in its key
+// attribute otherwise.
+func xmlElement(key string) xml.StartElement {
+	if isXMLName(key) {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{
+		Name: xml.Name{Local: "entry"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+	}
+}
+
+// isXMLName reports whether s can be used as an element name. It accepts a
+// conservative subset of the XML Name production: a letter or underscore followed by
+// letters, digits, underscores, hyphens and dots. Colons are left out, as they would
+// be read as a namespace prefix, and so are names starting with "xml", which XML
+// reserves.
+func isXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// csvList returns the list held by data, if data holds a single list of records (a
+// slice of structs or of pointers to structs).
+func csvList(data envelope) (reflect.Value, bool) {
+	if len(data) != 1 {
+		return reflect.Value{}, false
+	}
+	for _, value := range data {
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice {
+			return reflect.Value{}, false
+		}
+		elem := v.Type().Elem()
+		if elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		return v, elem.Kind() == reflect.Struct
+	}
+	return reflect.Value{}, false
+}
+
+// encodeCSV writes the list of records held by data as CSV, with a header row holding
+// the JSON names of the fields. Lists of values (e.g. the genres) are joined with
+// commas into a single cell.
+func encodeCSV(w io.Writer, data envelope) error {
+	list, ok := csvList(data)
+	if !ok {
+		return errors.New("the response doesn't hold a list of records")
+	}
+
+	columns := csvHeader(list.Type().Elem())
+	cw := csv.NewWriter(w)
+	err := cw.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < list.Len(); i++ {
+		row, err := csvRecord(list.Index(i).Interface(), columns)
+		if err != nil {
+			return err
+		}
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvHeader returns the columns of the CSV encoding of a struct type (or pointer to a
+// struct type): the JSON names of its fields.
+func csvHeader(t reflect.Type) []string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+// csvRecord returns the cells of the CSV encoding of record, for the columns returned
+// by csvHeader().
+func csvRecord(record any, columns []string) ([]string, error) {
+	// Go through the JSON encoding of the record, so that the cells hold the same
+	// values as the JSON response.
+	js, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	err = dec.Decode(&fields)
+	if err != nil {
+		return nil, err
+	}
+
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		row[i] = csvCell(fields[column])
+	}
+	return row, nil
+}
+
+// csvCell formats a JSON value as a CSV cell.
+func csvCell(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case []any:
+		cells := make([]string, len(value))
+		for i, v := range value {
+			cells[i] = csvCell(v)
+		}
+		return strings.Join(cells, ", ")
+	case map[string]any:
+		js, _ := json.Marshal(value)
+		return string(js)
+	default:
+		return fmt.Sprint(value)
+	}
+}
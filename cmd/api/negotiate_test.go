@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{mediaJSON, mediaXML, mediaMsgPack, mediaCSV}
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", mediaJSON},
+		{"*/*", mediaJSON},
+		{"text/csv", mediaCSV},
+		{"text/*", mediaCSV},
+		{"text/xml", mediaXML},
+		{"application/x-msgpack", mediaMsgPack},
+		{"application/json;q=0.5, application/xml", mediaXML},
+		{"text/csv;q=0, */*;q=0.1", mediaJSON},
+		{"image/png", ""},
+		{"application/problem+json", mediaJSON},
+	}
+
+	for _, tt := range tests {
+		if got := negotiate(tt.accept, offers); got != tt.want {
+			t.Errorf("negotiate(%q) = %q; want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWriteResponseFormats(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}})
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		accept   string
+		wantCode int
+		wantType string
+		wantBody string
+		wantETag string
+	}{
+		{"CSV list", "/v1/movies", "text/csv", http.StatusOK, mediaCSV,
+			"id,title,year,runtime,genres,version\n1,Moana,2016,107,\"animation, adventure\",1\n", ""},
+		{"XML movie", "/v1/movies/1", "application/xml", http.StatusOK, mediaXML,
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<response><movie><id>1</id><title>Moana</title><year>2016</year><runtime>107</runtime>` +
+				`<genres><item>animation</item><item>adventure</item></genres><version>1</version></movie></response>`,
+			`"1-1-xml"`},
+		{"Problem documents only", "/v1/movies/1", "application/problem+json", http.StatusOK, mediaJSON, "", `"1-1"`},
+		{"CSV movie", "/v1/movies/1", "text/csv", http.StatusNotAcceptable, mediaJSON, "", ""},
+		{"Unsupported type", "/v1/movies", "image/png", http.StatusNotAcceptable, mediaJSON, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.do(t, http.MethodGet, tt.urlPath, "", http.Header{"Accept": {tt.accept}})
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d", code, tt.wantCode)
+			}
+			if got := headers.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("got Content-Type %q; want %q", got, tt.wantType)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("got body %q; want %q", body, tt.wantBody)
+			}
+			if tt.wantETag != "" && headers.Get("ETag") != tt.wantETag {
+				t.Errorf("got ETag %q; want %q", headers.Get("ETag"), tt.wantETag)
+			}
+		})
+	}
+
+	code, _, body := ts.do(t, http.MethodGet, "/v1/movies/1", "", http.Header{"Accept": {mediaMsgPack}})
+	if code != http.StatusOK {
+		t.Fatalf("msgpack: got status %d; want %d", code, http.StatusOK)
+	}
+	var rs struct {
+		Movie struct {
+			Title  string   `msgpack:"title"`
+			Genres []string `msgpack:"genres"`
+		} `msgpack:"movie"`
+	}
+	if err := msgpack.Unmarshal(body, &rs); err != nil {
+		t.Fatal(err)
+	}
+	if rs.Movie.Title != "Moana" || len(rs.Movie.Genres) != 2 {
+		t.Errorf("msgpack: got %+v", rs.Movie)
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	data := envelope{"error": map[string]string{
+		"genres[0]": "must be provided",
+		"a<b c":     "must be a name",
+		"title":     "must be provided",
+	}}
+
+	var buf bytes.Buffer
+	if err := encodeXML(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+
+	var rs struct {
+		XMLName xml.Name `xml:"response"`
+		Error   struct {
+			Title   string `xml:"title"`
+			Entries []struct {
+				Key   string `xml:"key,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"entry"`
+		} `xml:"error"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &rs); err != nil {
+		t.Fatalf("unmarshal %q: %v", buf.String(), err)
+	}
+
+	if rs.Error.Title != "must be provided" {
+		t.Errorf("got title %q; want %q", rs.Error.Title, "must be provided")
+	}
+	got := make(map[string]string)
+	for _, e := range rs.Error.Entries {
+		got[e.Key] = e.Value
+	}
+	want := map[string]string{"a<b c": "must be a name", "genres[0]": "must be provided"}
+	if len(got) != len(want) {
+		t.Fatalf("got entries %v; want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("got entry %q = %q; want %q", key, got[key], value)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// acceptPatch lists the patch formats accepted by the PATCH endpoints. It is sent in the
+// Accept-Patch header (RFC 5789) when a client uses another one.
+const acceptPatch = "application/merge-patch+json, application/json-patch+json"
+
+var (
+	// errUnsupportedPatch is returned by readPatch() when the Content-Type of the
+	// request isn't one of the patch formats in acceptPatch.
+	errUnsupportedPatch = errors.New("unsupported patch format")
+
+	// errPatchTestFailed is returned by readPatch() when a "test" operation of a JSON
+	// Patch doesn't hold.
+	errPatchTestFailed = errors.New("a test operation of the patch failed")
+)
+
+// The readPatch() helper applies the patch in the request body to dst, which must be
+// a pointer to a struct holding the current state of the record. The format of the
+// patch is chosen by the Content-Type header:
+//
+//   - application/merge-patch+json is a JSON Merge Patch (RFC 7396): the keys in the
+//     body replace those of the record, and a null value clears them. Plain
+//     application/json bodies are treated the same way, which is what the PATCH
+//     endpoints used to accept.
+//   - application/json-patch+json is a JSON Patch (RFC 6902): a list of operations
+//     such as {"op": "add", "path": "/genres/-", "value": "drama"}. All of add, remove,
+//     replace, move, copy and test are supported.
+//
+// The patch is applied to the JSON encoding of dst, which is then decoded back into
+// dst. Adding keys which don't exist in dst is an error. The caller is expected to
+// validate dst afterwards.
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, dst any) error {
+	current, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+
+	// The same size limit as in readJSON() applies.
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, app.config.maxBodyBytes))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return err
+	}
+
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedPatch
+		}
+	}
+
+	var patched []byte
+	switch mediaType {
+	case "application/json", "application/merge-patch+json":
+		if len(bytes.TrimSpace(body)) == 0 {
+			return errors.New("body must not be empty")
+		}
+		if !json.Valid(body) {
+			return errors.New("body contains badly-formed JSON")
+		}
+		patched, err = jsonpatch.MergePatch(current, body)
+		if err != nil {
+			return fmt.Errorf("body contains an invalid merge patch: %s", err)
+		}
+
+	case "application/json-patch+json":
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return fmt.Errorf("body contains an invalid JSON patch: %s", err)
+		}
+		patched, err = patch.Apply(current)
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			return errPatchTestFailed
+		case err != nil:
+			return fmt.Errorf("unable to apply the JSON patch: %s", err)
+		}
+
+	default:
+		return errUnsupportedPatch
+	}
+
+	// Start from an empty value, so that the keys removed by the patch are cleared.
+	v := reflect.ValueOf(dst).Elem()
+	v.Set(reflect.Zero(v.Type()))
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(dst)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("the patch sets an incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("the patch sets unknown key %s", fieldName)
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The patchErrorResponse() helper sends the response matching an error returned by
+// readPatch().
+func (app *application) patchErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedPatch):
+		w.Header().Set("Accept-Patch", acceptPatch)
+		app.unsupportedMediaTypeResponse(w, r)
+	case errors.Is(err, errPatchTestFailed):
+		app.problemResponse(w, r, http.StatusConflict, problemPatchTestFailed, err.Error())
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestPatchMovie(t *testing.T) {
+	const (
+		mergePatch = "application/merge-patch+json"
+		jsonPatch  = "application/json-patch+json"
+	)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantGenres  []string
+	}{
+		{"Merge patch", mergePatch, `{"genres": ["animation", "musical"]}`, http.StatusOK, []string{"animation", "musical"}},
+		{"Plain JSON", "application/json", `{"runtime": 110}`, http.StatusOK, []string{"animation"}},
+		{"JSON patch add", jsonPatch, `[{"op": "add", "path": "/genres/-", "value": "musical"}]`, http.StatusOK, []string{"animation", "musical"}},
+		{"JSON patch test and replace", jsonPatch, `[{"op": "test", "path": "/genres/0", "value": "animation"}, {"op": "replace", "path": "/genres/0", "value": "cartoon"}]`, http.StatusOK, []string{"cartoon"}},
+		{"JSON patch failed test", jsonPatch, `[{"op": "test", "path": "/title", "value": "Up"}]`, http.StatusConflict, nil},
+		{"JSON patch remove", jsonPatch, `[{"op": "remove", "path": "/genres/0"}]`, http.StatusUnprocessableEntity, nil},
+		{"Merge patch null", mergePatch, `{"title": null}`, http.StatusUnprocessableEntity, nil},
+		{"Duplicate genres", mergePatch, `{"genres": ["animation", "animation"]}`, http.StatusUnprocessableEntity, nil},
+		{"Read-only key", mergePatch, `{"version": 10}`, http.StatusBadRequest, nil},
+		{"Wrong type", mergePatch, `{"year": "2017"}`, http.StatusBadRequest, nil},
+		{"Badly-formed JSON", mergePatch, `{"year": `, http.StatusBadRequest, nil},
+		{"Unsupported format", "text/plain", `year=2017`, http.StatusUnsupportedMediaType, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+			ts := newTestServer(t, app.routes())
+
+			code, headers, body := ts.do(t, http.MethodPatch, "/v1/movies/1", tt.body, http.Header{"Content-Type": {tt.contentType}})
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if code == http.StatusUnsupportedMediaType && headers.Get("Accept-Patch") != acceptPatch {
+				t.Errorf("got Accept-Patch %q; want %q", headers.Get("Accept-Patch"), acceptPatch)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var rs struct {
+				Movie data.Movie `json:"movie"`
+			}
+			decode(t, body, &rs)
+			if !reflect.DeepEqual(rs.Movie.Genres, tt.wantGenres) {
+				t.Errorf("got genres %q; want %q", rs.Movie.Genres, tt.wantGenres)
+			}
+			if rs.Movie.Version != 2 {
+				t.Errorf("got version %d; want 2", rs.Movie.Version)
+			}
+		})
+	}
+}
+
+func TestPatchActorAndDirector(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+	err := app.models.Actor.INSERTACTOR(ctx, &data.Actor{Fullname: "Dwayne Johnson", Year: 1972, Films: []string{"Moana"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Directors.InsertDirector(ctx, &data.Directors{Name: "Ron", Surname: "Clements"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, app.routes())
+
+	code, _, body := ts.do(t, http.MethodPatch, "/v1/actor/1", `[{"op": "add", "path": "/films/-", "value": "Jumanji"}]`,
+		http.Header{"Content-Type": {"application/json-patch+json"}})
+	if code != http.StatusOK {
+		t.Fatalf("actor: got status %d; want %d", code, http.StatusOK)
+	}
+	var actor struct {
+		Actor data.Actor `json:"actor"`
+	}
+	decode(t, body, &actor)
+	if want := []string{"Moana", "Jumanji"}; !reflect.DeepEqual(actor.Actor.Films, want) {
+		t.Errorf("actor: got films %q; want %q", actor.Actor.Films, want)
+	}
+
+	code, _, _ = ts.do(t, http.MethodPatch, "/v1/directors/1", `{"awords": ["Annie Award"]}`,
+		http.Header{"Content-Type": {"application/merge-patch+json"}})
+	if code != http.StatusOK {
+		t.Fatalf("director: got status %d; want %d", code, http.StatusOK)
+	}
+	code, _, body = ts.do(t, http.MethodGet, "/v1/directors/1", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("director: got status %d; want %d", code, http.StatusOK)
+	}
+	var director struct {
+		Director data.Directors `json:"director"`
+	}
+	decode(t, body, &director)
+	want := data.Directors{ID: 1, Name: "Ron", Surname: "Clements", Awords: []string{"Annie Award"}}
+	if !reflect.DeepEqual(director.Director, want) {
+		t.Errorf("director: got %+v; want %+v", director.Director, want)
+	}
+
+	code, _, _ = ts.do(t, http.MethodPatch, "/v1/directors/1", `{"surname": ""}`, nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("director: got status %d for an empty surname; want %d", code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The problem types of the error responses, sent in the "type" member of the
+// application/problem+json documents (RFC 7807). Clients can rely on them to tell the
+// errors apart: unlike the messages, they never change.
+const (
+	problemBadRequest           = "urn:greenlight:problem:bad-request"
+	problemNotFound             = "urn:greenlight:problem:not-found"
+	problemMethodNotAllowed     = "urn:greenlight:problem:method-not-allowed"
+	problemNotAcceptable        = "urn:greenlight:problem:not-acceptable"
+	problemEditConflict         = "urn:greenlight:problem:edit-conflict"
+	problemPatchTestFailed      = "urn:greenlight:problem:patch-test-failed"
+	problemPreconditionFailed   = "urn:greenlight:problem:precondition-failed"
+	problemUnsupportedMediaType = "urn:greenlight:problem:unsupported-media-type"
+	problemFailedValidation     = "urn:greenlight:problem:failed-validation"
+	problemIdempotencyKeyInUse  = "urn:greenlight:problem:idempotency-key-in-use"
+	problemIdempotencyKeyReused = "urn:greenlight:problem:idempotency-key-reused"
+	problemServerError          = "urn:greenlight:problem:server-error"
+)
+
+// problemTypes maps the status codes sent by errorResponse() to their problem type.
+// Other status codes use "about:blank", which means that the status code says it all.
+var problemTypes = map[int]string{
+	http.StatusBadRequest:           problemBadRequest,
+	http.StatusNotFound:             problemNotFound,
+	http.StatusMethodNotAllowed:     problemMethodNotAllowed,
+	http.StatusNotAcceptable:        problemNotAcceptable,
+	http.StatusConflict:             problemEditConflict,
+	http.StatusPreconditionFailed:   problemPreconditionFailed,
+	http.StatusUnsupportedMediaType: problemUnsupportedMediaType,
+	http.StatusUnprocessableEntity:  problemFailedValidation,
+	http.StatusInternalServerError:  problemServerError,
+}
+
+// problemDetails is an application/problem+json document. Errors lists the invalid
+// fields of a failed validation, and RequestID is the ID of the request (see the
+// requestID middleware); both are extension members.
+type problemDetails struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// The writeProblem() helper sends an application/problem+json document. The message
+// passed to errorResponse() becomes the detail, or the errors member if it is the map
+// of invalid fields sent by failedValidationResponse().
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, status int, problemType string, message any) error {
+	if problemType == "" {
+		problemType = "about:blank"
+	}
+
+	problem := problemDetails{
+		Type:      problemType,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.RequestURI(),
+		RequestID: app.contextGetRequestID(r),
+	}
+	switch message := message.(type) {
+	case string:
+		problem.Detail = message
+	case map[string]string:
+		problem.Detail = "one or more fields are invalid"
+		problem.Errors = message
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/problem+json")
+	return app.writeJSON(w, status, problem, headers)
+}
+
+// The wantsProblem() helper reports whether the errors of the request must be sent as
+// application/problem+json documents: either because -error-format is "problem", or
+// because the client listed application/problem+json in its Accept header.
+func (app *application) wantsProblem(r *http.Request) bool {
+	if app.config.errorFormat == "problem" {
+		return true
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil || mediaType != "application/problem+json" {
+			continue
+		}
+		// A zero quality value means "not acceptable".
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			return false
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+func TestProblemResponses(t *testing.T) {
+	app := newTestApplication(t)
+	seedMovies(t, app, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+	ts := newTestServer(t, app.routes())
+
+	accept := http.Header{"Accept": {"application/problem+json"}, "X-Request-Id": {"abc-123"}}
+
+	code, headers, body := ts.do(t, http.MethodGet, "/v1/movies/2?x=1", "", accept)
+	if code != http.StatusNotFound {
+		t.Fatalf("got status %d; want %d", code, http.StatusNotFound)
+	}
+	if got := headers.Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %q; want application/problem+json", got)
+	}
+	var problem problemDetails
+	decode(t, body, &problem)
+	want := problemDetails{
+		Type:      problemNotFound,
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "the requested resource could not be found",
+		Instance:  "/v1/movies/2?x=1",
+		RequestID: "abc-123",
+	}
+	if !reflect.DeepEqual(problem, want) {
+		t.Errorf("got %+v; want %+v", problem, want)
+	}
+
+	// Validation errors are listed in the errors member.
+	code, _, body = ts.do(t, http.MethodPatch, "/v1/movies/1", `{"title": ""}`, accept)
+	if code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d; want %d", code, http.StatusUnprocessableEntity)
+	}
+	problem = problemDetails{}
+	decode(t, body, &problem)
+	if problem.Type != problemFailedValidation || problem.Errors["title"] != "must be provided" {
+		t.Errorf("got %+v; want a %s problem with a title error", problem, problemFailedValidation)
+	}
+
+	// Other clients keep getting the {"error": ...} object, unless the problem
+	// format is configured for everyone.
+	_, headers, _ = ts.do(t, http.MethodGet, "/v1/movies/2", "", nil)
+	if got := headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q without Accept; want application/json", got)
+	}
+	app.config.errorFormat = "problem"
+	_, headers, _ = ts.do(t, http.MethodGet, "/v1/movies/2", "", nil)
+	if got := headers.Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %q with -error-format=problem; want application/problem+json", got)
+	}
+}
@@ -1,31 +1,79 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
+	router := app.router()
+
+	// Wrap the router with the middleware chain. The request ID has to be assigned
+	// first, so that it is available to the access log and the error responses. The
+	// access log records the size of the response as sent, after compression.
+	return app.metrics(app.requestID(app.logRequest(app.compress(app.enableCORS(app.readYourWrites(router))))))
+}
+
+// The router() method returns the router of the API, without the middleware. POST
+// /v1/batch dispatches its sub-requests through it.
+func (app *application) router() *httprouter.Router {
 	// Initialize a new httprouter router instance.
 	router := httprouter.New()
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
-
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/actor", app.createActorHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/directors", app.createDirectorHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/actor/:id", app.showActorHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/actor/:id", app.updateActorHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieeHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/actor/:id", app.deleteActorHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMoviesHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/directors", app.listDirectorsHandler)
-
-	// Return the httprouter instance.
+	router.NotFound = app.instrumentRoute("unmatched", http.HandlerFunc(app.notFoundResponse))
+	router.MethodNotAllowed = app.instrumentRoute("unmatched", http.HandlerFunc(app.methodNotAllowedResponse))
+
+	// The handle() helper registers a handler with the router, wrapping it so that the
+	// Prometheus metrics are labeled with the route pattern rather than the raw URL
+	// path (which would create a new series for every movie ID).
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.Handler(method, pattern, app.instrumentRoute(pattern, handler))
+	}
+
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthz", app.healthcheckHandler)
+	handle(http.MethodGet, "/v1/readyz", app.readinessHandler)
+	handle(http.MethodPost, "/v1/movies", app.idempotent(app.createMovieHandler))
+	handle(http.MethodPost, "/v1/movies/import", app.idempotentBulk(app.importMoviesHandler))
+	handle(http.MethodPost, "/v1/batch", app.idempotent(app.batchHandler))
+	handle(http.MethodPost, "/v1/actor", app.idempotent(app.createActorHandler))
+	handle(http.MethodPost, "/v1/directors", app.idempotent(app.createDirectorHandler))
+	// httprouter doesn't let a fixed path segment sit where the :id parameter is, so
+	// GET /v1/movies/export is dispatched by the /v1/movies/:id route.
+	showMovie := app.instrumentRoute("/v1/movies/:id", http.HandlerFunc(app.showMovieHandler))
+	exportMovies := app.instrumentRoute("/v1/movies/export", http.HandlerFunc(app.exportMoviesHandler))
+	router.Handler(http.MethodGet, "/v1/movies/:id", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "export" {
+			exportMovies.ServeHTTP(w, r)
+			return
+		}
+		showMovie.ServeHTTP(w, r)
+	}))
+	handle(http.MethodGet, "/v1/actor/:id", app.showActorHandler)
+	handle(http.MethodPut, "/v1/movies/:id", app.updateMovieHandler)
+	handle(http.MethodPut, "/v1/actor/:id", app.updateActorHandler)
+	handle(http.MethodPatch, "/v1/movies/:id", app.updateMovieeHandler)
+	handle(http.MethodPatch, "/v1/actor/:id", app.patchActorHandler)
+	handle(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	handle(http.MethodDelete, "/v1/actor/:id", app.deleteActorHandler)
+	handle(http.MethodGet, "/v1/movies", app.listMoviesHandler)
+	handle(http.MethodGet, "/v1/directors", app.listDirectorsHandler)
+	handle(http.MethodGet, "/v1/directors/:id", app.showDirectorHandler)
+	handle(http.MethodPatch, "/v1/directors/:id", app.patchDirectorHandler)
+
+	return router
+}
+
+// The metricsRoutes() method returns the handler of the -metrics-addr listener: the
+// expvar handler, which exposes the published application metrics as JSON, and the
+// Prometheus handler, which exposes them in the text format scraped by our monitoring
+// stack. They reveal the database pool and cache statistics and the version, so they
+// are kept off the public API.
+func (app *application) metricsRoutes() http.Handler {
+	router := httprouter.New()
+	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
 	return router
 }
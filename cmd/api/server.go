@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The serve() method starts the HTTP server and blocks until it has shut down. When a
+// SIGINT or SIGTERM signal is received the readiness probe starts failing, and after the
+// configured drain period the server stops accepting new connections and waits for the
+// in-flight requests to complete.
+func (app *application) serve() error {
+	// Use the httprouter instance returned by app.routes() as the server handler.
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", app.config.port),
+		Handler: app.routes(),
+		// Create a new Go log.Logger instance which writes to our custom Logger, so
+		// that any errors logged by the http.Server end up as JSON entries as well.
+		ErrorLog:     log.New(app.logger, "", 0),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	// The metrics are served by a server of their own, on an address which isn't
+	// exposed publicly (see metricsRoutes).
+	var metricsSrv *http.Server
+	if app.config.metricsAddr != "" {
+		metricsSrv = &http.Server{
+			Addr:         app.config.metricsAddr,
+			Handler:      app.metricsRoutes(),
+			ErrorLog:     log.New(app.logger, "", 0),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		go func() {
+			app.logger.PrintInfo("starting metrics server", map[string]string{"addr": metricsSrv.Addr})
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": metricsSrv.Addr})
+			}
+		}()
+	}
+
+	// The shutdownError channel receives any error returned by the graceful
+	// Shutdown() function.
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.PrintInfo("shutting down server", map[string]string{
+			"signal": s.String(),
+		})
+
+		// Report the instance as not ready, and give the load balancer some time to
+		// notice it before we stop accepting new connections.
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.shutdownDrainPeriod)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if metricsSrv != nil {
+			_ = metricsSrv.Shutdown(ctx)
+		}
+		shutdownError <- srv.Shutdown(ctx)
+	}()
+
+	app.logger.PrintInfo("starting server", map[string]string{
+		"addr": srv.Addr,
+		"env":  app.config.env,
+	})
+
+	// Calling Shutdown() makes ListenAndServe() return http.ErrServerClosed straight
+	// away, so that error is expected and means the shutdown has started.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("stopped server", map[string]string{
+		"addr": srv.Addr,
+	})
+	return nil
+}
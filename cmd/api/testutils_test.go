@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/jsonlog"
+)
+
+// newTestApplication returns an application backed by the in-memory models, with
+// logging switched off.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "testing"
+	cfg.maxBodyBytes = 1_048_576
+	cfg.bulk.maxBytes = 1_048_576
+	cfg.bulk.timeout = time.Minute
+	cfg.idempotency.ttl = time.Hour
+
+	return &application{
+		config: cfg,
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+		models: data.NewMemoryModels(),
+	}
+}
+
+// testServer wraps an httptest.Server running the application routes.
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// do sends a request with an optional body to the test server and returns the
+// response status code, headers and body.
+func (ts *testServer) do(t *testing.T, method, urlPath, body string, headers http.Header) (int, http.Header, []byte) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, b
+}
+
+// decode unmarshals a JSON response body into dst, failing the test on error.
+func decode(t *testing.T, body []byte, dst any) {
+	t.Helper()
+
+	err := json.Unmarshal(body, dst)
+	if err != nil {
+		t.Fatalf("unable to decode response body %q: %s", body, err)
+	}
+}
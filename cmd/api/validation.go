@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// validate checks the `validate` struct tags of the request inputs. It is shared by the
+// handlers, as a validator caches what it learns about each struct type.
+var validate = newValidator()
+
+// newValidator returns a validator which names the fields after their JSON keys, so
+// that the errors match what the client sent, and which knows the "notfuture" tag
+// (a year which is not after the current one).
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	_ = v.RegisterValidation("notfuture", func(fl validator.FieldLevel) bool {
+		return fl.Field().Int() <= int64(time.Now().Year())
+	})
+	return v
+}
+
+// validationErrors checks input against its `validate` struct tags, and returns a map of
+// the invalid fields (by JSON key) to a message describing the problem, or nil if the
+// input is valid.
+func validationErrors(input any) map[string]string {
+	err := validate.Struct(input)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return map[string]string{"input": err.Error()}
+	}
+
+	errs := make(map[string]string, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		// Report the first problem found with each field.
+		if _, exists := errs[fe.Field()]; !exists {
+			errs[fe.Field()] = validationMessage(fe)
+		}
+	}
+	return errs
+}
+
+// validationMessage describes a failed validation tag in plain English.
+func validationMessage(fe validator.FieldError) string {
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " bytes long"
+	case reflect.Slice:
+		unit = " items"
+	}
+
+	switch fe.Tag() {
+	case "required":
+		return "must be provided"
+	case "min":
+		return fmt.Sprintf("must contain at least %s%s", fe.Param(), unit)
+	case "max":
+		return fmt.Sprintf("must not be more than %s%s", fe.Param(), unit)
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be greater than or equal to %s", fe.Param())
+	case "unique":
+		return "must not contain duplicate values"
+	case "notfuture":
+		return "must not be in the future"
+	default:
+		return fmt.Sprintf("failed the %q check", fe.Tag())
+	}
+}
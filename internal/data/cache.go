@@ -0,0 +1,348 @@
+package data
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// cacheRequests is the Prometheus counter of the cache lookups, labeled by the cache
+// ("movies" or "actors") and the result ("hit" or "miss").
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "greenlight",
+	Subsystem: "cache",
+	Name:      "requests_total",
+	Help:      "Number of cache lookups by cache and result.",
+}, []string{"cache", "result"})
+
+// Cache is a size-bounded, in-process cache of the movies and actors looked up by ID.
+// Entries are evicted when they are older than the TTL, when the cache is full (least
+// recently used first), and when the record is updated or deleted through the cached
+// models. Use Models.WithCache to put it in front of the models.
+//
+// The cache is only filled from the primary database: a row read from a lagging replica
+// may predate an update whose eviction has already happened, and would then be served
+// until the TTL runs out. For the same reason, a row read from the primary isn't cached
+// if an entry was evicted while it was being read (see lruCache.generation).
+type Cache struct {
+	movies *lruCache[Movie]
+	actors *lruCache[Actor]
+}
+
+// NewCache returns a Cache holding up to size movies and size actors, each for at most
+// ttl.
+func NewCache(size int, ttl time.Duration) *Cache {
+	return &Cache{
+		movies: newLRUCache[Movie]("movies", size, ttl),
+		actors: newLRUCache[Actor]("actors", size, ttl),
+	}
+}
+
+// EvictMovie removes the movie with the given ID from the cache.
+func (c *Cache) EvictMovie(id int64) {
+	c.movies.remove(id)
+}
+
+// EvictActor removes the actor with the given ID from the cache.
+func (c *Cache) EvictActor(id int64) {
+	c.actors.remove(id)
+}
+
+// Purge removes every entry from the cache.
+func (c *Cache) Purge() {
+	c.movies.purge()
+	c.actors.purge()
+}
+
+// Stats returns the size and the hit/miss counters of each cache, for expvar.
+func (c *Cache) Stats() map[string]any {
+	return map[string]any{
+		"movies": c.movies.stats(),
+		"actors": c.actors.stats(),
+	}
+}
+
+// WithCache returns a copy of the models where MovieRepository.Get and
+// ActorRepository.GetActors are served from c when possible. Updates and deletes made
+// through the returned models, including inside WithTx, evict the records from c.
+func (m Models) WithCache(c *Cache) Models {
+	m.Movies = cachedMovieModel{MovieRepository: m.Movies, cache: c.movies}
+	m.Actor = cachedActorModel{ActorRepository: m.Actor, cache: c.actors}
+
+	runTx := m.runTx
+	if runTx == nil {
+		return m
+	}
+	m.runTx = func(ctx context.Context, fn func(tx Models) error) error {
+		// Reads inside a transaction must see its own uncommitted writes, so they
+		// bypass the cache. The records written are evicted again once the
+		// transaction is over, in case a concurrent request cached the old version
+		// before the commit.
+		var evicted []int64
+		var evictedActors []int64
+		err := runTx(ctx, func(tx Models) error {
+			tx.Movies = evictingMovieModel{MovieRepository: tx.Movies, evict: func(id int64) {
+				c.EvictMovie(id)
+				evicted = append(evicted, id)
+			}}
+			tx.Actor = evictingActorModel{ActorRepository: tx.Actor, evict: func(id int64) {
+				c.EvictActor(id)
+				evictedActors = append(evictedActors, id)
+			}}
+			// Nested calls to WithTx get the same wrapped models.
+			tx.runTx = func(ctx context.Context, fn func(tx Models) error) error {
+				return fn(tx)
+			}
+			return fn(tx)
+		})
+		for _, id := range evicted {
+			c.EvictMovie(id)
+		}
+		for _, id := range evictedActors {
+			c.EvictActor(id)
+		}
+		return err
+	}
+	return m
+}
+
+// cachedMovieModel serves Get from the cache and evicts the movies it updates or
+// deletes.
+type cachedMovieModel struct {
+	MovieRepository
+	cache *lruCache[Movie]
+}
+
+func (m cachedMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	// A client asking to read from the primary wants the latest version, so skip the
+	// lookup but still refresh the cache with what we read.
+	if !usePrimary(ctx) {
+		if movie, ok := m.cache.get(id); ok {
+			return copyMovie(&movie), nil
+		}
+	}
+
+	// Misses are read from the primary, as what we read is cached (see Cache).
+	gen := m.cache.generation()
+	movie, err := m.MovieRepository.Get(UsePrimary(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	m.cache.addIfUnchanged(id, *copyMovie(movie), gen)
+	return movie, nil
+}
+
+func (m cachedMovieModel) Update(ctx context.Context, movie *Movie) error {
+	defer m.cache.remove(movie.ID)
+	return m.MovieRepository.Update(ctx, movie)
+}
+
+func (m cachedMovieModel) Delete(ctx context.Context, id int64) error {
+	defer m.cache.remove(id)
+	return m.MovieRepository.Delete(ctx, id)
+}
+
+// cachedActorModel serves GetActors from the cache and evicts the actors it updates or
+// deletes.
+type cachedActorModel struct {
+	ActorRepository
+	cache *lruCache[Actor]
+}
+
+func (m cachedActorModel) GetActors(ctx context.Context, id int64) (*Actor, error) {
+	if !usePrimary(ctx) {
+		if actor, ok := m.cache.get(id); ok {
+			return copyActor(&actor), nil
+		}
+	}
+
+	gen := m.cache.generation()
+	actor, err := m.ActorRepository.GetActors(UsePrimary(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	m.cache.addIfUnchanged(id, *copyActor(actor), gen)
+	return actor, nil
+}
+
+func (m cachedActorModel) UpdateActor(ctx context.Context, actor *Actor) error {
+	defer m.cache.remove(actor.ID)
+	return m.ActorRepository.UpdateActor(ctx, actor)
+}
+
+func (m cachedActorModel) DeleteActor(ctx context.Context, id int64) error {
+	defer m.cache.remove(id)
+	return m.ActorRepository.DeleteActor(ctx, id)
+}
+
+// evictingMovieModel is used inside transactions: it doesn't cache anything, but
+// reports the movies it updates or deletes.
+type evictingMovieModel struct {
+	MovieRepository
+	evict func(id int64)
+}
+
+func (m evictingMovieModel) Update(ctx context.Context, movie *Movie) error {
+	m.evict(movie.ID)
+	return m.MovieRepository.Update(ctx, movie)
+}
+
+func (m evictingMovieModel) Delete(ctx context.Context, id int64) error {
+	m.evict(id)
+	return m.MovieRepository.Delete(ctx, id)
+}
+
+// evictingActorModel is the actor counterpart of evictingMovieModel.
+type evictingActorModel struct {
+	ActorRepository
+	evict func(id int64)
+}
+
+func (m evictingActorModel) UpdateActor(ctx context.Context, actor *Actor) error {
+	m.evict(actor.ID)
+	return m.ActorRepository.UpdateActor(ctx, actor)
+}
+
+func (m evictingActorModel) DeleteActor(ctx context.Context, id int64) error {
+	m.evict(id)
+	return m.ActorRepository.DeleteActor(ctx, id)
+}
+
+// lruCache is a thread-safe least recently used cache of values keyed by record ID,
+// whose entries expire after ttl.
+type lruCache[V any] struct {
+	name string
+	size int
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	ll     *list.List // most recently used entry at the front
+	items  map[int64]*list.Element
+	hits   int64
+	misses int64
+	gen    uint64 // incremented by every remove() and purge()
+}
+
+type lruEntry[V any] struct {
+	key     int64
+	value   V
+	expires time.Time
+}
+
+func newLRUCache[V any](name string, size int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		name:  name,
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[int64]*list.Element),
+	}
+}
+
+// get returns the value cached for key, if there is one and it hasn't expired.
+func (c *lruCache[V]) get(key int64) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		if time.Now().Before(entry.expires) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			cacheRequests.WithLabelValues(c.name, "hit").Inc()
+			return entry.value, true
+		}
+		c.removeElement(el)
+	}
+
+	c.misses++
+	cacheRequests.WithLabelValues(c.name, "miss").Inc()
+	var zero V
+	return zero, false
+}
+
+// add caches value for key, evicting the least recently used entry if the cache is
+// full.
+func (c *lruCache[V]) add(key int64, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addLocked(key, value)
+}
+
+// addLocked is add() for callers which hold c.mu.
+func (c *lruCache[V]) addLocked(key int64, value V) {
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		entry.value, entry.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry[V]{key: key, value: value, expires: expires})
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// generation returns a number which changes whenever an entry is removed, to be passed
+// to addIfUnchanged().
+func (c *lruCache[V]) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// addIfUnchanged caches value for key, like add(), unless an entry has been removed
+// since gen was returned by generation(). The value was read before gen changed, so it
+// may be the version a concurrent write has just replaced. Any removal counts, not just
+// that of key, which only costs the odd extra miss.
+func (c *lruCache[V]) addIfUnchanged(key int64, value V, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gen == gen {
+		c.addLocked(key, value)
+	}
+}
+
+func (c *lruCache[V]) remove(key int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lruCache[V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.ll.Init()
+	c.items = make(map[int64]*list.Element)
+}
+
+func (c *lruCache[V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry[V]).key)
+}
+
+func (c *lruCache[V]) stats() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]int64{
+		"size":   int64(c.ll.Len()),
+		"hits":   c.hits,
+		"misses": c.misses,
+	}
+}
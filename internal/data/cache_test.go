@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCachedMovieModel(t *testing.T) {
+	ctx := context.Background()
+	cache := NewCache(10, time.Minute)
+	models := NewMemoryModels().WithCache(cache)
+
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	if err := models.Movies.Insert(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first lookup misses, the second one is served from the cache. Changing the
+	// returned movie mustn't change the cached one.
+	first, err := models.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Genres[0] = "changed"
+	second, err := models.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Genres[0] != "animation" {
+		t.Errorf("got genre %q from the cache; want %q", second.Genres[0], "animation")
+	}
+	if got := cache.movies.stats(); got["hits"] != 1 || got["misses"] != 1 {
+		t.Errorf("got stats %v; want 1 hit and 1 miss", got)
+	}
+
+	// Updates evict the movie, so the next lookup sees the new version.
+	second.Title = "Moana 2"
+	if err := models.Movies.Update(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	third, err := models.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Title != "Moana 2" || third.Version != 2 {
+		t.Errorf("got %q version %d after update; want %q version 2", third.Title, third.Version, "Moana 2")
+	}
+
+	// So do updates made in a transaction.
+	err = models.WithTx(ctx, func(tx Models) error {
+		third.Year = 2024
+		return tx.Movies.Update(ctx, third)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := models.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fourth.Year != 2024 {
+		t.Errorf("got year %d after a transactional update; want 2024", fourth.Year)
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[int]("test", 2, time.Minute)
+
+	c.add(1, 1)
+	c.add(2, 2)
+	c.get(1) // 2 is now the least recently used entry
+	c.add(3, 3)
+
+	if _, ok := c.get(2); ok {
+		t.Error("got entry 2; want it evicted")
+	}
+	for _, key := range []int64{1, 3} {
+		if v, ok := c.get(key); !ok || v != int(key) {
+			t.Errorf("got %d, %t for entry %d; want %d, true", v, ok, key, key)
+		}
+	}
+
+	// Expired entries are never returned.
+	c.ttl = -time.Second
+	c.add(4, 4)
+	if _, ok := c.get(4); ok {
+		t.Error("got expired entry 4")
+	}
+}
+
+func TestCacheHandleNotification(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	cache.movies.add(42, Movie{ID: 42})
+	cache.actors.add(42, Actor{ID: 42})
+
+	if err := cache.HandleNotification("movies:42"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.movies.get(42); ok {
+		t.Error("got movie 42 from the cache after its notification")
+	}
+	if _, ok := cache.actors.get(42); !ok {
+		t.Error("actor 42 was evicted by a movie notification")
+	}
+
+	for _, payload := range []string{"", "movies", "movies:abc", "directors:1"} {
+		if err := cache.HandleNotification(payload); err == nil {
+			t.Errorf("got no error for payload %q", payload)
+		}
+	}
+}
+
+// primaryRecordingMovieModel records whether Get was asked to read from the primary.
+type primaryRecordingMovieModel struct {
+	MovieRepository
+	primary *bool
+}
+
+func (m primaryRecordingMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	*m.primary = usePrimary(ctx)
+	return m.MovieRepository.Get(ctx, id)
+}
+
+func TestCachedMovieModelReadsPrimary(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	if err := models.Movies.Insert(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+
+	// A miss fills the cache, so it mustn't be served by a lagging replica.
+	var primary bool
+	models.Movies = primaryRecordingMovieModel{MovieRepository: models.Movies, primary: &primary}
+	models = models.WithCache(NewCache(10, time.Minute))
+	if _, err := models.Movies.Get(ctx, movie.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !primary {
+		t.Error("got a cache miss read from the replica; want the primary")
+	}
+}
+
+// hookedMovieModel calls beforeReturn once Get has read a movie, before returning it.
+type hookedMovieModel struct {
+	MovieRepository
+	beforeReturn func()
+}
+
+func (m hookedMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	movie, err := m.MovieRepository.Get(ctx, id)
+	if m.beforeReturn != nil {
+		m.beforeReturn()
+	}
+	return movie, err
+}
+
+func TestCachedMovieModelStaleFill(t *testing.T) {
+	ctx := context.Background()
+	base := NewMemoryModels()
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	if err := base.Movies.Insert(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+
+	// An update lands while a cache miss is reading the movie: the version read is
+	// stale, and mustn't be cached.
+	cache := NewCache(10, time.Minute)
+	hooked := &hookedMovieModel{MovieRepository: base.Movies}
+	base.Movies = hooked
+	models := base.WithCache(cache)
+	hooked.beforeReturn = func() {
+		hooked.beforeReturn = nil
+		updated := *movie
+		updated.Title = "Moana 2"
+		if err := models.Movies.Update(ctx, &updated); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := models.Movies.Get(ctx, movie.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := models.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Moana 2" {
+		t.Errorf("got title %q after the update; want %q", got.Title, "Moana 2")
+	}
+}
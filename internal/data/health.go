@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// RequiredSchemaVersion is the migration version the models in this package expect the
+// database schema to be at. Bump it whenever a new migration is added.
+const RequiredSchemaVersion int64 = 7
+
+// Define a HealthModel struct type which wraps a sql.DB connection pool and is used by
+// the readiness probe to check the state of the database.
+type HealthModel struct {
+	DB      *sql.DB
+	Replica *sql.DB // optional read replica
+}
+
+// Ping verifies that a connection to the database, and to the read replica if there is
+// one, can still be established.
+func (m HealthModel) Ping(ctx context.Context) error {
+	err := m.DB.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+	if m.Replica != nil {
+		err = m.Replica.PingContext(ctx)
+		if err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the database, as
+// recorded by goose in the goose_db_version table. Goose deletes the row of a migration
+// when it is rolled back, so the most recent applied row holds the current version.
+func (m HealthModel) SchemaVersion(ctx context.Context) (int64, error) {
+	query := `
+SELECT version_id FROM goose_db_version
+WHERE is_applied
+ORDER BY id DESC
+LIMIT 1`
+
+	var version int64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return version, nil
+}
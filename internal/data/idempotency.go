@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyRecord is what is stored for an Idempotency-Key: the hash of the request
+// which first used it and, once that request has been handled, the response sent to
+// it. Status is 0 while the request is still being handled.
+type IdempotencyRecord struct {
+	RequestHash string
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+}
+
+// IdempotencyModel stores the idempotency keys in PostgreSQL.
+type IdempotencyModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Reserve claims key for the request whose hash is requestHash, until ttl has passed.
+// It returns nil if the key was free (or its record had expired), and the record of
+// the key otherwise, in which case nothing is changed.
+func (m IdempotencyModel) Reserve(ctx context.Context, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	defer observeQuery("idempotency_keys", "Reserve", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	// An expired record is replaced, as if the key had never been used.
+	query := `
+INSERT INTO idempotency_keys (key, request_hash, expires_at)
+VALUES ($1, $2, NOW() + make_interval(secs => $3))
+ON CONFLICT (key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, status = 0, header = '{}', body = NULL,
+	created_at = NOW(), expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at <= NOW()
+RETURNING key`
+	for {
+		var reserved string
+		err := m.DB.QueryRowContext(ctx, query, key, requestHash, ttl.Seconds()).Scan(&reserved)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		record, err := m.get(ctx, key)
+		if !errors.Is(err, ErrRecordNotFound) {
+			return record, err
+		}
+		// The key has been released or has expired since the INSERT: try again.
+	}
+}
+
+// get returns the record of an unexpired key.
+func (m IdempotencyModel) get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	query := `
+SELECT request_hash, status, header, body FROM idempotency_keys
+WHERE key = $1 AND expires_at > NOW()`
+	var record IdempotencyRecord
+	var header []byte
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&record.RequestHash, &record.Status, &header, &record.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &record, json.Unmarshal(header, &record.Header)
+}
+
+// Complete stores the response sent to the request which reserved key.
+func (m IdempotencyModel) Complete(ctx context.Context, key string, status int, header map[string][]string, body []byte) error {
+	defer observeQuery("idempotency_keys", "Complete", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	js, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	query := `
+UPDATE idempotency_keys SET status = $2, header = $3, body = $4
+WHERE key = $1`
+	_, err = m.DB.ExecContext(ctx, query, key, status, js, body)
+	return err
+}
+
+// Release frees a key reserved by a request which couldn't be handled, so that the
+// client can retry it. Keys holding a response aren't released.
+func (m IdempotencyModel) Release(ctx context.Context, key string) error {
+	defer observeQuery("idempotency_keys", "Release", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status = 0`, key)
+	return err
+}
+
+// DeleteExpired deletes the expired records, and returns how many there were.
+func (m IdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	defer observeQuery("idempotency_keys", "DeleteExpired", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,775 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The in-memory repositories below mirror the behaviour of the PostgreSQL models
+// (ErrRecordNotFound, version checks and ErrEditConflict, full-text and array
+// filtering, sorting and paging) closely enough for the handlers to be tested without
+// a database. They are safe for concurrent use. Records are copied on the way in and
+// out, so callers can't modify the stored data behind the repository's back.
+
+// MemoryMovieModel is an in-memory implementation of MovieRepository.
+type MemoryMovieModel struct {
+	mu     sync.RWMutex
+	nextID int64
+	gen    uint64 // incremented on every write, to detect conflicting transactions
+	movies map[int64]*Movie
+}
+
+// NewMemoryMovieModel returns an empty MemoryMovieModel.
+func NewMemoryMovieModel() *MemoryMovieModel {
+	return &MemoryMovieModel{nextID: 1, movies: make(map[int64]*Movie)}
+}
+
+func (m *MemoryMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie.ID = m.nextID
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.UpdatedAt = movie.CreatedAt
+	movie.Version = 1
+	m.nextID++
+
+	m.movies[movie.ID] = copyMovie(movie)
+	m.gen++
+	return nil
+}
+
+// InsertBatch inserts the movies one by one, as the validation is done by the handler.
+func (m *MemoryMovieModel) InsertBatch(ctx context.Context, movies []*Movie) error {
+	for _, movie := range movies {
+		err := m.Insert(ctx, movie)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.movies[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyMovie(movie), nil
+}
+
+func (m *MemoryMovieModel) GetByTitle(ctx context.Context, title string) (*Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, movie := range m.sorted(Filters{Sort: "id", SortSafelist: []string{"id"}}) {
+		if movie.Title == title {
+			return copyMovie(movie), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// Update replaces the stored movie and increments its version, as long as the version
+// of the given movie matches the stored one. Otherwise it returns ErrEditConflict,
+// which is also what MovieModel.Update returns when the movie has been deleted.
+func (m *MemoryMovieModel) Update(ctx context.Context, movie *Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.movies[movie.ID]
+	if !ok || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	movie.UpdatedAt = time.Now().Truncate(time.Second)
+	updated := copyMovie(movie)
+	updated.CreatedAt = stored.CreatedAt
+	m.movies[movie.ID] = updated
+	m.gen++
+	return nil
+}
+
+func (m *MemoryMovieModel) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[id]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.movies, id)
+	m.gen++
+	return nil
+}
+
+func (m *MemoryMovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := []*Movie{}
+	for _, movie := range m.sorted(filters) {
+		if (title == "" || matchesText(movie.Title, title)) && containsAll(movie.Genres, genres) {
+			matched = append(matched, movie)
+		}
+	}
+
+	movies := []*Movie{}
+	for _, i := range paginate(len(matched), filters) {
+		movies = append(movies, copyMovie(matched[i]))
+	}
+	return movies, nil
+}
+
+// Export calls fn with a copy of every movie matching the filters, like
+// MovieModel.Export. The lock isn't held while fn runs.
+func (m *MemoryMovieModel) Export(ctx context.Context, title string, genres []string, filters Filters, fn func(movie *Movie) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	matched := []*Movie{}
+	for _, movie := range m.sorted(filters) {
+		if (title == "" || matchesText(movie.Title, title)) && containsAll(movie.Genres, genres) {
+			matched = append(matched, copyMovie(movie))
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, movie := range matched {
+		err := fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sorted returns the stored movies ordered like the ORDER BY clause in
+// MovieModel.GetAll: by the filter's sort column and direction, then by ascending ID.
+// The caller must hold the lock.
+func (m *MemoryMovieModel) sorted(filters Filters) []*Movie {
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	movies := make([]*Movie, 0, len(m.movies))
+	for _, movie := range m.movies {
+		movies = append(movies, movie)
+	}
+	sort.Slice(movies, func(i, j int) bool {
+		a, b := movies[i], movies[j]
+		var c int
+		switch column {
+		case "title":
+			c = strings.Compare(a.Title, b.Title)
+		case "year":
+			c = compareInt(int64(a.Year), int64(b.Year))
+		case "runtime":
+			c = compareInt(int64(a.Runtime), int64(b.Runtime))
+		default:
+			c = compareInt(a.ID, b.ID)
+		}
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+	return movies
+}
+
+// MemoryActorModel is an in-memory implementation of ActorRepository.
+type MemoryActorModel struct {
+	mu     sync.RWMutex
+	nextID int64
+	gen    uint64
+	actors map[int64]*Actor
+}
+
+// NewMemoryActorModel returns an empty MemoryActorModel.
+func NewMemoryActorModel() *MemoryActorModel {
+	return &MemoryActorModel{nextID: 1, actors: make(map[int64]*Actor)}
+}
+
+func (m *MemoryActorModel) INSERTACTOR(ctx context.Context, actor *Actor) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	actor.ID = m.nextID
+	actor.CreatedAt = time.Now().Truncate(time.Second)
+	m.nextID++
+
+	m.actors[actor.ID] = copyActor(actor)
+	m.gen++
+	return nil
+}
+
+func (m *MemoryActorModel) GetActors(ctx context.Context, id int64) (*Actor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	actor, ok := m.actors[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyActor(actor), nil
+}
+
+func (m *MemoryActorModel) UpdateActor(ctx context.Context, actor *Actor) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.actors[actor.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	updated := copyActor(actor)
+	updated.CreatedAt = stored.CreatedAt
+	m.actors[actor.ID] = updated
+	m.gen++
+	return nil
+}
+
+func (m *MemoryActorModel) DeleteActor(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.actors[id]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.actors, id)
+	m.gen++
+	return nil
+}
+
+// MemoryDirectorModel is an in-memory implementation of DirectorRepository.
+type MemoryDirectorModel struct {
+	mu        sync.RWMutex
+	nextID    int64
+	gen       uint64
+	directors map[int64]*Directors
+}
+
+// NewMemoryDirectorModel returns an empty MemoryDirectorModel.
+func NewMemoryDirectorModel() *MemoryDirectorModel {
+	return &MemoryDirectorModel{nextID: 1, directors: make(map[int64]*Directors)}
+}
+
+func (m *MemoryDirectorModel) InsertDirector(ctx context.Context, directors *Directors) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	directors.ID = m.nextID
+	m.nextID++
+
+	m.directors[directors.ID] = copyDirector(directors)
+	m.gen++
+	return nil
+}
+
+func (m *MemoryDirectorModel) GetDirector(ctx context.Context, id int64) (*Directors, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	directors, ok := m.directors[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyDirector(directors), nil
+}
+
+func (m *MemoryDirectorModel) UpdateDirector(ctx context.Context, directors *Directors) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.directors[directors.ID]; !ok {
+		return ErrRecordNotFound
+	}
+	m.directors[directors.ID] = copyDirector(directors)
+	m.gen++
+	return nil
+}
+
+// GetAllDirectors filters on name and awords only, like DirectorModel.GetAllDirectors.
+func (m *MemoryDirectorModel) GetAllDirectors(ctx context.Context, name string, surname string, awords []string, filters Filters) ([]*Directors, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	matched := []*Directors{}
+	for _, director := range m.directors {
+		if (name == "" || matchesText(director.Name, name)) && containsAll(director.Awords, awords) {
+			matched = append(matched, director)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		var c int
+		switch column {
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		case "surname":
+			c = strings.Compare(a.Surname, b.Surname)
+		case "awords":
+			c = compareStrings(a.Awords, b.Awords)
+		default:
+			c = compareInt(a.ID, b.ID)
+		}
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+
+	directors := []*Directors{}
+	for _, i := range paginate(len(matched), filters) {
+		directors = append(directors, copyDirector(matched[i]))
+	}
+	return directors, nil
+}
+
+// MemoryHealthModel is an in-memory implementation of HealthChecker which always
+// reports the storage as reachable and up to date.
+type MemoryHealthModel struct{}
+
+func (m MemoryHealthModel) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m MemoryHealthModel) SchemaVersion(ctx context.Context) (int64, error) {
+	return RequiredSchemaVersion, ctx.Err()
+}
+
+// memoryTxRunner returns a txRunner for the in-memory models. Each transaction runs
+// against private copies of the three models, which replace the shared ones when the
+// transaction commits. If any of the shared models has been written to since the copies
+// were taken, the commit fails with ErrSerializationFailure, like a SERIALIZABLE
+// transaction would in PostgreSQL. A rolled back transaction just discards its copies.
+//
+// The idempotency keys get a private copy too, so that a rolled back transaction
+// doesn't keep the keys of the requests it ran. As every request writes its own key,
+// only the keys the transaction wrote to are checked for conflicts and published.
+func memoryTxRunner(movies *MemoryMovieModel, actors *MemoryActorModel, directors *MemoryDirectorModel, idempotency *MemoryIdempotencyModel) txRunner {
+	return func(ctx context.Context, fn func(tx Models) error) error {
+		txMovies, txActors, txDirectors := movies.clone(), actors.clone(), directors.clone()
+		baseMovies, baseActors, baseDirectors := txMovies.gen, txActors.gen, txDirectors.gen
+		txIdempotency := idempotency.clone()
+
+		models := Models{
+			Movies:      txMovies,
+			Actor:       txActors,
+			Directors:   txDirectors,
+			Idempotency: txIdempotency,
+			Health:      MemoryHealthModel{},
+		}
+		// Nested calls to WithTx run in the transaction that is already open.
+		models.runTx = func(ctx context.Context, fn func(tx Models) error) error {
+			return fn(models)
+		}
+
+		err := fn(models)
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Lock the shared models, always in the same order, and publish the copies.
+		movies.mu.Lock()
+		defer movies.mu.Unlock()
+		actors.mu.Lock()
+		defer actors.mu.Unlock()
+		directors.mu.Lock()
+		defer directors.mu.Unlock()
+		idempotency.mu.Lock()
+		defer idempotency.mu.Unlock()
+
+		if movies.gen != baseMovies || actors.gen != baseActors || directors.gen != baseDirectors {
+			return ErrSerializationFailure
+		}
+		if idempotency.changed(txIdempotency.written) {
+			return ErrSerializationFailure
+		}
+		if txMovies.gen != baseMovies {
+			movies.movies, movies.nextID, movies.gen = txMovies.movies, txMovies.nextID, movies.gen+1
+		}
+		if txActors.gen != baseActors {
+			actors.actors, actors.nextID, actors.gen = txActors.actors, txActors.nextID, actors.gen+1
+		}
+		if txDirectors.gen != baseDirectors {
+			directors.directors, directors.nextID, directors.gen = txDirectors.directors, txDirectors.nextID, directors.gen+1
+		}
+		for key := range txIdempotency.written {
+			if record, ok := txIdempotency.records[key]; ok {
+				idempotency.records[key] = record
+			} else {
+				delete(idempotency.records, key)
+			}
+		}
+		return nil
+	}
+}
+
+// clone returns a copy of the model for use in a transaction. The stored records are
+// never modified in place, so the copy can share them with the original.
+func (m *MemoryMovieModel) clone() *MemoryMovieModel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := &MemoryMovieModel{nextID: m.nextID, gen: m.gen, movies: make(map[int64]*Movie, len(m.movies))}
+	for id, movie := range m.movies {
+		c.movies[id] = movie
+	}
+	return c
+}
+
+func (m *MemoryActorModel) clone() *MemoryActorModel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := &MemoryActorModel{nextID: m.nextID, gen: m.gen, actors: make(map[int64]*Actor, len(m.actors))}
+	for id, actor := range m.actors {
+		c.actors[id] = actor
+	}
+	return c
+}
+
+func (m *MemoryDirectorModel) clone() *MemoryDirectorModel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := &MemoryDirectorModel{nextID: m.nextID, gen: m.gen, directors: make(map[int64]*Directors, len(m.directors))}
+	for id, directors := range m.directors {
+		c.directors[id] = directors
+	}
+	return c
+}
+
+// paginate returns the indexes of the records on the page selected by filters, out of
+// n sorted records, like the LIMIT and OFFSET clauses do.
+func paginate(n int, filters Filters) []int {
+	start := filters.offset()
+	if start < 0 {
+		start = 0
+	}
+	end := start + filters.limit()
+	if end > n {
+		end = n
+	}
+
+	indexes := []int{}
+	for i := start; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// matchesText approximates to_tsvector('simple', text) @@ plainto_tsquery('simple',
+// query): every word of the query must appear as a word in text, ignoring case.
+func matchesText(text, query string) bool {
+	terms := textSearchWords(query)
+	if len(terms) == 0 {
+		return false
+	}
+
+	words := map[string]bool{}
+	for _, word := range textSearchWords(text) {
+		words[word] = true
+	}
+	for _, term := range terms {
+		if !words[term] {
+			return false
+		}
+	}
+	return true
+}
+
+func textSearchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsAll reports whether values contains every element of wanted, like the
+// PostgreSQL array operator @>.
+func containsAll(values, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, v := range values {
+			if v == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareStrings compares two text arrays element by element, like PostgreSQL does.
+func compareStrings(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	c.Genres = append([]string(nil), movie.Genres...)
+	return &c
+}
+
+func copyActor(actor *Actor) *Actor {
+	c := *actor
+	c.Films = append([]string(nil), actor.Films...)
+	return &c
+}
+
+func copyDirector(directors *Directors) *Directors {
+	c := *directors
+	c.Awords = append([]string(nil), directors.Awords...)
+	return &c
+}
+
+// MemoryIdempotencyModel is an in-memory implementation of IdempotencyRepository.
+type MemoryIdempotencyModel struct {
+	mu      sync.Mutex
+	records map[string]memoryIdempotencyRecord
+	// written is only set on the copy used by a transaction (see memoryTxRunner). It
+	// holds the keys the transaction has written to, each with the expiry of the
+	// record it first found under the key, or the zero time if there was none.
+	written map[string]time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	IdempotencyRecord
+	expiresAt time.Time
+}
+
+// NewMemoryIdempotencyModel returns an empty MemoryIdempotencyModel.
+func NewMemoryIdempotencyModel() *MemoryIdempotencyModel {
+	return &MemoryIdempotencyModel{records: make(map[string]memoryIdempotencyRecord)}
+}
+
+func (m *MemoryIdempotencyModel) Reserve(ctx context.Context, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.records[key]; ok && time.Now().Before(record.expiresAt) {
+		return copyIdempotencyRecord(&record.IdempotencyRecord), nil
+	}
+	m.write(key)
+	m.records[key] = memoryIdempotencyRecord{
+		IdempotencyRecord: IdempotencyRecord{RequestHash: requestHash},
+		expiresAt:         time.Now().Add(ttl),
+	}
+	return nil, nil
+}
+
+func (m *MemoryIdempotencyModel) Complete(ctx context.Context, key string, status int, header map[string][]string, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.records[key]
+	if !ok {
+		return nil
+	}
+	record.Status, record.Header, record.Body = status, header, body
+	record.IdempotencyRecord = *copyIdempotencyRecord(&record.IdempotencyRecord)
+	m.write(key)
+	m.records[key] = record
+	return nil
+}
+
+func (m *MemoryIdempotencyModel) Release(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.records[key]; ok && record.Status == 0 {
+		m.write(key)
+		delete(m.records, key)
+	}
+	return nil
+}
+
+func (m *MemoryIdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for key, record := range m.records {
+		if !time.Now().Before(record.expiresAt) {
+			m.write(key)
+			delete(m.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// write notes, in the copy used by a transaction, that key is about to be written to.
+// The caller must hold m.mu.
+func (m *MemoryIdempotencyModel) write(key string) {
+	if m.written == nil {
+		return
+	}
+	if _, ok := m.written[key]; !ok {
+		m.written[key] = m.records[key].expiresAt
+	}
+}
+
+// changed reports whether any of the written keys of a transaction has been written to
+// since the transaction copied the model. The caller must hold m.mu.
+func (m *MemoryIdempotencyModel) changed(written map[string]time.Time) bool {
+	for key, expiresAt := range written {
+		// A missing record has the zero expiry, like a key which had none.
+		if !m.records[key].expiresAt.Equal(expiresAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of the model for use in a transaction. The records are never
+// modified in place, so the copy can share them with the original.
+func (m *MemoryIdempotencyModel) clone() *MemoryIdempotencyModel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &MemoryIdempotencyModel{
+		records: make(map[string]memoryIdempotencyRecord, len(m.records)),
+		written: make(map[string]time.Time),
+	}
+	for key, record := range m.records {
+		c.records[key] = record
+	}
+	return c
+}
+
+func copyIdempotencyRecord(r *IdempotencyRecord) *IdempotencyRecord {
+	c := *r
+	c.Body = append([]byte(nil), r.Body...)
+	c.Header = make(map[string][]string, len(r.Header))
+	for key, values := range r.Header {
+		c.Header[key] = append([]string(nil), values...)
+	}
+	return &c
+}
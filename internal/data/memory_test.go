@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestMemoryMovieModelUpdateConflict(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryMovieModel()
+
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	if err := m.Insert(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two clients read the same version of the movie...
+	first, err := m.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...the first update wins and bumps the version...
+	first.Title = "Moana 2"
+	if err := m.Update(ctx, first); err != nil {
+		t.Fatalf("first update: %s", err)
+	}
+	if first.Version != 2 {
+		t.Errorf("got version %d after update; want 2", first.Version)
+	}
+
+	// ...and the second one is rejected.
+	second.Year = 2024
+	if err := m.Update(ctx, second); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("second update: got error %v; want %v", err, ErrEditConflict)
+	}
+
+	if err := m.Delete(ctx, movie.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(ctx, movie.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("get after delete: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestMemoryMovieModelConcurrentInsert(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryMovieModel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = m.Insert(ctx, &Movie{Title: "Moana", Genres: []string{"animation"}})
+		}()
+	}
+	wg.Wait()
+
+	filters := Filters{Page: 1, PageSize: 100, Sort: "id", SortSafelist: []string{"id"}}
+	movies, err := m.GetAll(ctx, "", nil, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 50 {
+		t.Fatalf("got %d movies; want 50", len(movies))
+	}
+	for i, movie := range movies {
+		if movie.ID != int64(i+1) {
+			t.Fatalf("got id %d at position %d; want %d", movie.ID, i, i+1)
+		}
+	}
+}
+
+func TestMemoryModelsWithTx(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+	errRollback := errors.New("rollback")
+
+	// A failing transaction leaves no trace...
+	err := models.WithTx(ctx, func(tx Models) error {
+		if err := tx.Movies.Insert(ctx, &Movie{Title: "Moana", Genres: []string{"animation"}}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got error %v; want %v", err, errRollback)
+	}
+
+	// ...and neither does a panicking one, while the panic is propagated.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic wasn't propagated")
+			}
+		}()
+		_ = models.WithTx(ctx, func(tx Models) error {
+			_ = tx.Actor.INSERTACTOR(ctx, &Actor{Fullname: "Dwayne Johnson"})
+			panic("boom")
+		})
+	}()
+
+	if _, err := models.Movies.Get(ctx, 1); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("movie inserted by a rolled back transaction: got error %v; want %v", err, ErrRecordNotFound)
+	}
+	if _, err := models.Actor.GetActors(ctx, 1); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("actor inserted by a rolled back transaction: got error %v; want %v", err, ErrRecordNotFound)
+	}
+
+	// A transaction which conflicts with a concurrent write is retried, and the
+	// writes of both end up committed.
+	attempts := 0
+	err = models.WithTx(ctx, func(tx Models) error {
+		attempts++
+		if attempts == 1 {
+			if err := models.Directors.InsertDirector(ctx, &Directors{Name: "Ron"}); err != nil {
+				return err
+			}
+		}
+		if err := tx.Movies.Insert(ctx, &Movie{Title: "Moana", Genres: []string{"animation"}}); err != nil {
+			return err
+		}
+		return tx.Actor.INSERTACTOR(ctx, &Actor{Fullname: "Dwayne Johnson"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts; want 2", attempts)
+	}
+	if _, err := models.Movies.Get(ctx, 1); err != nil {
+		t.Errorf("movie inserted by the committed transaction: %v", err)
+	}
+	if _, err := models.Actor.GetActors(ctx, 1); err != nil {
+		t.Errorf("actor inserted by the committed transaction: %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package data
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// queryDuration is the Prometheus histogram of the time spent in each model method,
+// labeled by the model and the method name.
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "greenlight",
+	Subsystem: "db",
+	Name:      "query_duration_seconds",
+	Help:      "Duration of database queries by model and method.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"model", "method"})
+
+// observeQuery records the time elapsed since start for the given model method. It is
+// meant to be deferred at the top of the method:
+//
+//	defer observeQuery("movies", "Get", time.Now())
+func observeQuery(model, method string, start time.Time) {
+	queryDuration.WithLabelValues(model, method).Observe(time.Since(start).Seconds())
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -12,17 +14,129 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// MovieRepository is the set of operations the handlers need on movies. It is
+// implemented by MovieModel (PostgreSQL) and MemoryMovieModel (in-memory, for tests).
+type MovieRepository interface {
+	Insert(ctx context.Context, movie *Movie) error
+	InsertBatch(ctx context.Context, movies []*Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetByTitle(ctx context.Context, title string) (*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, error)
+	Export(ctx context.Context, title string, genres []string, filters Filters, fn func(movie *Movie) error) error
+}
+
+// ActorRepository is the set of operations the handlers need on actors.
+type ActorRepository interface {
+	INSERTACTOR(ctx context.Context, actor *Actor) error
+	GetActors(ctx context.Context, id int64) (*Actor, error)
+	UpdateActor(ctx context.Context, actor *Actor) error
+	DeleteActor(ctx context.Context, id int64) error
+}
+
+// DirectorRepository is the set of operations the handlers need on directors.
+type DirectorRepository interface {
+	InsertDirector(ctx context.Context, directors *Directors) error
+	GetDirector(ctx context.Context, id int64) (*Directors, error)
+	UpdateDirector(ctx context.Context, directors *Directors) error
+	GetAllDirectors(ctx context.Context, name string, surname string, awords []string, filters Filters) ([]*Directors, error)
+}
+
+// IdempotencyRepository stores the Idempotency-Key of the create requests and the
+// responses sent to them.
+type IdempotencyRepository interface {
+	Reserve(ctx context.Context, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, key string, status int, header map[string][]string, body []byte) error
+	Release(ctx context.Context, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// HealthChecker is used by the readiness probe to check the state of the storage.
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (int64, error)
+}
+
+// Make sure at compile time that the PostgreSQL, SQLite and in-memory models satisfy
+// the repository interfaces.
+var (
+	_ MovieRepository       = MovieModel{}
+	_ ActorRepository       = ActorModel{}
+	_ DirectorRepository    = DirectorModel{}
+	_ HealthChecker         = HealthModel{}
+	_ IdempotencyRepository = IdempotencyModel{}
+	_ MovieRepository       = SQLiteMovieModel{}
+	_ ActorRepository       = SQLiteActorModel{}
+	_ DirectorRepository    = SQLiteDirectorModel{}
+	_ IdempotencyRepository = SQLiteIdempotencyModel{}
+	_ MovieRepository       = (*MemoryMovieModel)(nil)
+	_ ActorRepository       = (*MemoryActorModel)(nil)
+	_ DirectorRepository    = (*MemoryDirectorModel)(nil)
+	_ IdempotencyRepository = (*MemoryIdempotencyModel)(nil)
+	_ HealthChecker         = MemoryHealthModel{}
+)
+
+// Models holds the repositories used by the application. Handlers only depend on the
+// interfaces, so the storage behind them can be swapped (e.g. for tests).
 type Models struct {
-	Movies    MovieModel
-	Actor     ActorModel
-	Directors DirectorModel
+	Movies      MovieRepository
+	Actor       ActorRepository
+	Directors   DirectorRepository
+	Idempotency IdempotencyRepository
+	Health      HealthChecker
+
+	// runTx runs a function inside a transaction, see WithTx.
+	runTx txRunner
 }
 
-func NewModels(db *sql.DB) Models {
-	return Models{
-		Movies:    MovieModel{DB: db},
-		Actor:     ActorModel{DB: db},
-		Directors: DirectorModel{DB: db},
+// NewModels returns a Models struct with every model bound to the given connection
+// pool. If replica isn't nil, the read-only methods query it instead of db (see
+// UsePrimary). Each query is cancelled if it takes longer than queryTimeout, or as soon
+// as the context passed to the model method is done (e.g. the client disconnected).
+func NewModels(db, replica *sql.DB, queryTimeout time.Duration) Models {
+	// Leave the replica as a nil interface rather than a nil *sql.DB when there isn't
+	// one, so that readerFor() falls back to the primary.
+	var replicaConn DBTX
+	if replica != nil {
+		replicaConn = replica
+	}
+
+	models := Models{
+		Movies:      MovieModel{DB: db, Replica: replicaConn, QueryTimeout: queryTimeout},
+		Actor:       ActorModel{DB: db, Replica: replicaConn, QueryTimeout: queryTimeout},
+		Directors:   DirectorModel{DB: db, Replica: replicaConn, QueryTimeout: queryTimeout},
+		Idempotency: IdempotencyModel{DB: db, QueryTimeout: queryTimeout},
+		Health:      HealthModel{DB: db, Replica: replica},
 	}
 
+	// Inside a transaction every query, reads included, goes through the transaction.
+	bind := func(tx DBTX) Models {
+		return Models{
+			Movies:      MovieModel{DB: tx, QueryTimeout: queryTimeout},
+			Actor:       ActorModel{DB: tx, QueryTimeout: queryTimeout},
+			Directors:   DirectorModel{DB: tx, QueryTimeout: queryTimeout},
+			Idempotency: IdempotencyModel{DB: tx, QueryTimeout: queryTimeout},
+			Health:      models.Health,
+		}
+	}
+	// Transactions run at the SERIALIZABLE isolation level, so that PostgreSQL
+	// detects the conflicts between concurrent transactions and WithTx retries them.
+	models.runTx = sqlTxRunner(db, &sql.TxOptions{Isolation: sql.LevelSerializable}, bind)
+	return models
+}
+
+// NewMemoryModels returns a Models struct backed by empty in-memory repositories. It
+// is meant for tests and doesn't need a database.
+func NewMemoryModels() Models {
+	movies, actors, directors := NewMemoryMovieModel(), NewMemoryActorModel(), NewMemoryDirectorModel()
+	idempotency := NewMemoryIdempotencyModel()
+	return Models{
+		Movies:      movies,
+		Actor:       actors,
+		Directors:   directors,
+		Idempotency: idempotency,
+		Health:      MemoryHealthModel{},
+		runTx:       memoryTxRunner(movies, actors, directors, idempotency),
+	}
 }
@@ -15,6 +15,7 @@ import (
 type Movie struct {
 	ID        int64     `json:"id"`                       // Unique integer ID for the movie
 	CreatedAt time.Time `json:"-"`                        // Timestamp for when the movie is added to our database, "-" directive, hidden in response
+	UpdatedAt time.Time `json:"-"`                        // Timestamp of the last update, used for the Last-Modified header
 	Title     string    `json:"title"`                    // Movie title
 	Year      int32     `json:"year,omitempty"`           // Movie release year, "omitempty" - hide from response if empty
 	Runtime   int32     `json:"runtime,omitempty,string"` // Movie runtime (in minutes), "string" - convert int to string
@@ -39,53 +40,226 @@ type Directors struct {
 	Awords  []string `json:"awords,omitempty"`
 }
 
-// Define a MovieModel struct type which wraps a sql.DB connection pool.
+// Define a MovieModel struct type which wraps a sql.DB connection pool (or a sql.Tx
+// transaction, see Models.WithTx).
 type MovieModel struct {
-	DB *sql.DB
+	DB           DBTX
+	Replica      DBTX          // optional read replica used by the read-only methods
+	QueryTimeout time.Duration // upper bound on the time a single query may take
 }
 type ActorModel struct {
-	DB *sql.DB
+	DB           DBTX
+	Replica      DBTX
+	QueryTimeout time.Duration
 }
 type DirectorModel struct {
-	DB *sql.DB
+	DB           DBTX
+	Replica      DBTX
+	QueryTimeout time.Duration
 }
 
-func (m DirectorModel) InsertDirector(directors *Directors) error {
+func (m DirectorModel) InsertDirector(ctx context.Context, directors *Directors) error {
+	defer observeQuery("directors", "InsertDirector", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	query := `
 		INSERT INTO directors(name, surname,awords)
 		VALUES ($1, $2, $3)
 		RETURNING id`
-	return m.DB.QueryRow(query, &directors.Name, &directors.Surname, pq.Array(&directors.Awords)).Scan(&directors.ID)
+	return m.DB.QueryRowContext(ctx, query, &directors.Name, &directors.Surname, pq.Array(&directors.Awords)).Scan(&directors.ID)
 }
 
-func (m ActorModel) INSERTACTOR(actor *Actor) error {
+func (m DirectorModel) GetDirector(ctx context.Context, id int64) (*Directors, error) {
+	defer observeQuery("directors", "GetDirector", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, name, surname, awords FROM directors
+WHERE id = $1`
+	var directors Directors
+	err := readerFor(ctx, m.DB, m.Replica).QueryRowContext(ctx, query, id).Scan(&directors.ID,
+		&directors.Name, &directors.Surname, pq.Array(&directors.Awords),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &directors, nil
+}
+
+// Directors have no version column, so the last update wins.
+func (m DirectorModel) UpdateDirector(ctx context.Context, directors *Directors) error {
+	defer observeQuery("directors", "UpdateDirector", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+UPDATE directors
+SET name = $1, surname = $2, awords = $3
+WHERE id = $4
+RETURNING id`
+	args := []any{directors.Name, directors.Surname, pq.Array(directors.Awords), directors.ID}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&directors.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ActorModel) INSERTACTOR(ctx context.Context, actor *Actor) error {
+	defer observeQuery("actors", "INSERTACTOR", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	query := `
 		INSERT INTO actor(fullname, year,girlfriend, films)
 		VALUES ($1, $2, $3,$4)
 		RETURNING id, created_at`
 
-	return m.DB.QueryRow(query, &actor.Fullname, &actor.Year, &actor.Girlfriend, pq.Array(&actor.Films)).Scan(&actor.ID, &actor.CreatedAt)
+	return m.DB.QueryRowContext(ctx, query, &actor.Fullname, &actor.Year, &actor.Girlfriend, pq.Array(&actor.Films)).Scan(&actor.ID, &actor.CreatedAt)
 }
 
 // method for inserting a new record in the movies table.
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+	defer observeQuery("movies", "Insert", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	query := `
 		INSERT INTO movies(title, year, runtime, genres)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
-	return m.DB.QueryRow(query, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres)).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		RETURNING id, created_at, updated_at, version`
+	return m.DB.QueryRowContext(ctx, query, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres)).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+}
+
+// InsertBatch inserts movies with a single COPY statement, which is much faster than
+// one INSERT per movie for large imports. Either every movie is inserted or none is.
+// COPY doesn't return the generated columns, so the movies are copied into a temporary
+// table along with their position in the batch, and moved into the movies table by an
+// INSERT which returns the generated columns of each movie next to its position.
+//
+// A batch of hundreds of movies takes longer than QueryTimeout allows a single query,
+// so InsertBatch is only bounded by ctx: the caller gives it its own deadline (the
+// import uses -bulk-timeout).
+func (m MovieModel) InsertBatch(ctx context.Context, movies []*Movie) error {
+	defer observeQuery("movies", "InsertBatch", time.Now())
+
+	// COPY has to run inside a transaction.
+	return inTx(ctx, m.DB, func(tx *sql.Tx) error {
+		// The table is dropped at the end of the batch rather than only on commit, so
+		// that the next batch of a transaction we're part of can create it again.
+		_, err := tx.ExecContext(ctx, `
+			CREATE TEMPORARY TABLE movies_import (
+				ord integer NOT NULL,
+				title text NOT NULL,
+				year integer NOT NULL,
+				runtime integer NOT NULL,
+				genres text[] NOT NULL
+			) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies_import", "ord", "title", "year", "runtime", "genres"))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for i, movie := range movies {
+			_, err = stmt.ExecContext(ctx, i, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+			if err != nil {
+				return err
+			}
+		}
+		// Executing the statement without arguments sends the buffered rows and ends
+		// the COPY.
+		_, err = stmt.ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		// RETURNING can only return the columns of movies, so the IDs are drawn from
+		// the sequence beforehand, and the inserted rows joined back to the ord they
+		// were drawn for.
+		query := `
+			WITH batch AS (
+				SELECT nextval(pg_get_serial_sequence('movies', 'id')) AS id, ord, title, year, runtime, genres
+				FROM movies_import
+				ORDER BY ord
+			), inserted AS (
+				INSERT INTO movies(id, title, year, runtime, genres)
+				SELECT id, title, year, runtime, genres FROM batch
+				RETURNING id, created_at, updated_at, version
+			)
+			SELECT batch.ord, inserted.id, inserted.created_at, inserted.updated_at, inserted.version
+			FROM inserted JOIN batch USING (id)`
+		rows, err := tx.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		n := 0
+		for rows.Next() {
+			var ord int
+			var movie Movie
+			err := rows.Scan(&ord, &movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+			if err != nil {
+				return err
+			}
+			if ord < 0 || ord >= len(movies) {
+				return fmt.Errorf("inserted a movie for unknown row %d", ord)
+			}
+			movies[ord].ID, movies[ord].CreatedAt, movies[ord].UpdatedAt, movies[ord].Version = movie.ID, movie.CreatedAt, movie.UpdatedAt, movie.Version
+			n++
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if n != len(movies) {
+			return fmt.Errorf("inserted %d movies out of %d", n, len(movies))
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE movies_import")
+		return err
+	})
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	defer observeQuery("movies", "Get", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-SELECT id, created_at, title, year, runtime, genres, version FROM movies
+SELECT id, created_at, updated_at, title, year, runtime, genres, version FROM movies
 WHERE id = $1`
 	var movie Movie
-	err := m.DB.QueryRow(query, id).Scan(&movie.ID,
-		&movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
+	err := readerFor(ctx, m.DB, m.Replica).QueryRowContext(ctx, query, id).Scan(&movie.ID,
+		&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
 	)
 	if err != nil {
 		switch {
@@ -97,7 +271,12 @@ WHERE id = $1`
 	}
 	return &movie, nil
 }
-func (m ActorModel) GetActors(id int64) (*Actor, error) {
+func (m ActorModel) GetActors(ctx context.Context, id int64) (*Actor, error) {
+	defer observeQuery("actors", "GetActors", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -105,7 +284,7 @@ func (m ActorModel) GetActors(id int64) (*Actor, error) {
 SELECT id, created_at, fullname, year, films, girlfriend FROM actor
 WHERE id = $1`
 	var actor Actor
-	err := m.DB.QueryRow(query, id).Scan(&actor.ID,
+	err := readerFor(ctx, m.DB, m.Replica).QueryRowContext(ctx, query, id).Scan(&actor.ID,
 		&actor.CreatedAt, &actor.Fullname, &actor.Year, pq.Array(&actor.Films), &actor.Girlfriend,
 	)
 	if err != nil {
@@ -118,16 +297,21 @@ WHERE id = $1`
 	}
 	return &actor, nil
 }
-func (m MovieModel) GetByTitle(title string) (*Movie, error) {
+func (m MovieModel) GetByTitle(ctx context.Context, title string) (*Movie, error) {
+	defer observeQuery("movies", "GetByTitle", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	if title < "null" {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-SELECT id, created_at, title, year, runtime, genres, version FROM movies
+SELECT id, created_at, updated_at, title, year, runtime, genres, version FROM movies
 WHERE title = $1`
 	var movie Movie
-	err := m.DB.QueryRow(query, title).Scan(&movie.ID,
-		&movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
+	err := readerFor(ctx, m.DB, m.Replica).QueryRowContext(ctx, query, title).Scan(&movie.ID,
+		&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
 	)
 	if err != nil {
 		switch {
@@ -140,7 +324,12 @@ WHERE title = $1`
 	return &movie, nil
 }
 
-func (m ActorModel) UpdateActor(actor *Actor) error {
+func (m ActorModel) UpdateActor(ctx context.Context, actor *Actor) error {
+	defer observeQuery("actors", "UpdateActor", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	// Declare the SQL query for updating the record and returning the new version     // number.
 	query := `
 	UPDATE actor
@@ -155,13 +344,29 @@ func (m ActorModel) UpdateActor(actor *Actor) error {
 		actor.ID,
 	}
 	// Use the QueryRow() method to execute the query, passing in the args slice as a     // variadic parameter and scanning the new version value into the movie struct.
-	return m.DB.QueryRow(query, args...).Scan(&actor.Girlfriend)
+	// No row means that the actor has been deleted since it was read.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&actor.Girlfriend)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
 }
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
+	defer observeQuery("movies", "Update", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	query := `
 UPDATE movies
-SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1 WHERE id = $5 AND version = $6
-RETURNING version`
+SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1, updated_at = NOW()
+WHERE id = $5 AND version = $6
+RETURNING version, updated_at`
 	args := []any{movie.Title,
 		movie.Year,
 		movie.Runtime,
@@ -170,7 +375,7 @@ RETURNING version`
 		movie.Version, // Add the expected movie version.
 	}
 	// Execute the SQL query. If no matching row could be found, we know the movie // version has changed (or the record has been deleted) and we return our custom // ErrEditConflict error.
-	err := m.DB.QueryRow(query, args...).Scan(&movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -181,13 +386,18 @@ RETURNING version`
 	}
 	return nil
 }
-func (m ActorModel) DeleteActor(id int64) error {
+func (m ActorModel) DeleteActor(ctx context.Context, id int64) error {
+	defer observeQuery("actors", "DeleteActor", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `DELETE FROM actor WHERE id = $1`
-	result, err := m.DB.Exec(query, id)
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -205,13 +415,18 @@ func (m ActorModel) DeleteActor(id int64) error {
 }
 
 // method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
+	defer observeQuery("movies", "Delete", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `DELETE FROM movies WHERE id = $1`
-	result, err := m.DB.Exec(query, id)
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -275,20 +490,22 @@ func (m MovieModel) Delete(id int64) error {
 //		// If everything went OK, then return the slice of movies.
 //		return movies, nil
 //	}
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, error) { // Update the SQL query to include the filter conditions.
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, error) { // Update the SQL query to include the filter conditions.
+	defer observeQuery("movies", "GetAll", time.Now())
+
 	query := fmt.Sprintf(`
-SELECT id, created_at, title, year, runtime, genres, version
+SELECT id, created_at, updated_at, title, year, runtime, genres, version
 FROM movies
 WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') AND (genres @> $2 OR $2 = '{}')
 ORDER BY %s %s, id ASC
 LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 	// As our SQL query now has quite a few placeholder parameters, let's collect the // values for the placeholders in a slice. Notice here how we call the limit() and // offset() methods on the Filters struct to get the appropriate values for the
 	// LIMIT and OFFSET clauses.
 	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
 	// And then pass the args slice to QueryContext() as a variadic parameter.
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := readerFor(ctx, m.DB, m.Replica).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -297,7 +514,7 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	for rows.Next() {
 		var movie Movie
 		err := rows.Scan(&movie.ID,
-			&movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
+			&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -310,19 +527,108 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	return movies, nil
 }
 
-func (m DirectorModel) GetAllDirectors(name string, surname string, awords []string, filters Filters) ([]*Directors, error) { // Update the SQL query to include the filter conditions.
+// exportFetchSize is the number of movies Export fetches from its cursor at a time.
+const exportFetchSize = 500
+
+// Export calls fn with every movie matching the title and genres filters of GetAll, in
+// the order set by filters (whose page and page size are ignored), and stops at the
+// first error fn returns. The movies are read through a server-side cursor,
+// exportFetchSize at a time, so that exporting the whole catalog never loads it into
+// memory.
+func (m MovieModel) Export(ctx context.Context, title string, genres []string, filters Filters, fn func(movie *Movie) error) error {
+	defer observeQuery("movies", "Export", time.Now())
+
+	query := fmt.Sprintf(`
+DECLARE movies_export NO SCROLL CURSOR FOR
+SELECT id, created_at, updated_at, title, year, runtime, genres, version
+FROM movies
+WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') AND (genres @> $2 OR $2 = '{}')
+ORDER BY %s %s, id ASC`, filters.sortColumn(), filters.sortDirection())
+
+	// A cursor only lives as long as the transaction which declared it. The export
+	// takes as long as the client needs to download it, so only the statements are
+	// bounded by the query timeout, not the transaction.
+	return inTx(ctx, readerFor(ctx, m.DB, m.Replica), func(tx *sql.Tx) error {
+		err := m.exec(ctx, tx, query, title, pq.Array(genres))
+		if err != nil {
+			return err
+		}
+		// Close the cursor in case the transaction goes on, e.g. inside WithTx.
+		defer m.exec(ctx, tx, "CLOSE movies_export")
+
+		fetch := fmt.Sprintf("FETCH FORWARD %d FROM movies_export", exportFetchSize)
+		for {
+			movies, err := m.fetch(ctx, tx, fetch)
+			if err != nil {
+				return err
+			}
+			// Call fn once the rows are closed, so that a slow client doesn't make the
+			// FETCH time out.
+			for _, movie := range movies {
+				err = fn(movie)
+				if err != nil {
+					return err
+				}
+			}
+			if len(movies) < exportFetchSize {
+				return nil
+			}
+		}
+	})
+}
+
+// exec runs a statement of Export with the query timeout.
+func (m MovieModel) exec(ctx context.Context, tx *sql.Tx, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// fetch runs a FETCH statement of Export with the query timeout, and returns the
+// movies it read.
+func (m MovieModel) fetch(ctx context.Context, tx *sql.Tx, query string) ([]*Movie, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	movies := make([]*Movie, 0, exportFetchSize)
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(&movie.ID,
+			&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+func (m DirectorModel) GetAllDirectors(ctx context.Context, name string, surname string, awords []string, filters Filters) ([]*Directors, error) { // Update the SQL query to include the filter conditions.
+	defer observeQuery("directors", "GetAllDirectors", time.Now())
+
 	query := fmt.Sprintf(`
 SELECT id, name, surname, awords
 FROM directors
 WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '') AND (awords @> $2 OR $2 = '{}')
 ORDER BY %s %s, id ASC
 LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 	// As our SQL query now has quite a few placeholder parameters, let's collect the // values for the placeholders in a slice. Notice here how we call the limit() and // offset() methods on the Filters struct to get the appropriate values for the
 	// LIMIT and OFFSET clauses.
 	args := []any{name, pq.Array(awords), filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := readerFor(ctx, m.DB, m.Replica).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
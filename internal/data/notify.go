@@ -0,0 +1,36 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CacheInvalidationChannel is the PostgreSQL notification channel on which the
+// triggers added by migration 000005 announce the movies and actors which were updated
+// or deleted.
+const CacheInvalidationChannel = "greenlight_cache"
+
+// HandleNotification evicts the record named by the payload of a notification received
+// on CacheInvalidationChannel. The payload has the form "<table>:<id>", e.g.
+// "movies:42".
+func (c *Cache) HandleNotification(payload string) error {
+	table, rawID, ok := strings.Cut(payload, ":")
+	if !ok {
+		return fmt.Errorf("invalid cache invalidation payload %q", payload)
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid cache invalidation payload %q", payload)
+	}
+
+	switch table {
+	case "movies":
+		c.EvictMovie(id)
+	case "actor":
+		c.EvictActor(id)
+	default:
+		return fmt.Errorf("invalid cache invalidation payload %q", payload)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package data
+
+import "context"
+
+// primaryContextKey is the context key marking that the read-only model methods must
+// query the primary database instead of the read replica.
+type primaryContextKey struct{}
+
+// UsePrimary returns a copy of ctx which makes the read-only model methods (Get,
+// GetAll, GetActors...) query the primary database, even when a read replica is
+// configured. It is used when a client needs to read its own writes, which may not have
+// reached the replica yet.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+// readerFor returns the connection a read-only query should run on: the replica if
+// there is one and ctx doesn't ask for the primary, the primary otherwise.
+func readerFor(ctx context.Context, primary, replica DBTX) DBTX {
+	if replica == nil {
+		return primary
+	}
+	if usePrimary(ctx) {
+		return primary
+	}
+	return replica
+}
+
+// usePrimary reports whether ctx was returned by UsePrimary.
+func usePrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryContextKey{}).(bool)
+	return primary
+}
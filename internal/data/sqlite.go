@@ -0,0 +1,613 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The SQLite models below implement the repositories on top of a SQLite database, for
+// local development and demos without PostgreSQL. The schema lives in
+// migrations/sqlite: text[] columns are stored as JSON arrays (see jsonArray), and the
+// full-text search on titles and names goes through FTS5 tables.
+
+// NewSQLiteModels returns a Models struct with every model bound to the given SQLite
+// connection pool.
+func NewSQLiteModels(db *sql.DB, queryTimeout time.Duration) Models {
+	bind := func(conn DBTX) Models {
+		return Models{
+			Movies:      SQLiteMovieModel{DB: conn, QueryTimeout: queryTimeout},
+			Actor:       SQLiteActorModel{DB: conn, QueryTimeout: queryTimeout},
+			Directors:   SQLiteDirectorModel{DB: conn, QueryTimeout: queryTimeout},
+			Idempotency: SQLiteIdempotencyModel{DB: conn, QueryTimeout: queryTimeout},
+			Health:      HealthModel{DB: db},
+		}
+	}
+
+	models := bind(db)
+	// SQLite transactions are always serializable, so the default options are used.
+	models.runTx = sqlTxRunner(db, nil, bind)
+	return models
+}
+
+// Define a SQLiteMovieModel struct type which wraps a SQLite connection pool.
+type SQLiteMovieModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+type SQLiteActorModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+type SQLiteDirectorModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+func (m SQLiteDirectorModel) InsertDirector(ctx context.Context, directors *Directors) error {
+	defer observeQuery("directors", "InsertDirector", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO directors(name, surname, awords)
+		VALUES (?1, ?2, ?3)
+		RETURNING id`
+	return m.DB.QueryRowContext(ctx, query, directors.Name, directors.Surname, jsonArray(&directors.Awords)).Scan(&directors.ID)
+}
+
+func (m SQLiteDirectorModel) GetDirector(ctx context.Context, id int64) (*Directors, error) {
+	defer observeQuery("directors", "GetDirector", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, name, surname, awords FROM directors
+WHERE id = ?1`
+	var directors Directors
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&directors.ID,
+		&directors.Name, &directors.Surname, jsonArray(&directors.Awords),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &directors, nil
+}
+
+func (m SQLiteDirectorModel) UpdateDirector(ctx context.Context, directors *Directors) error {
+	defer observeQuery("directors", "UpdateDirector", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+UPDATE directors
+SET name = ?1, surname = ?2, awords = ?3
+WHERE id = ?4
+RETURNING id`
+	args := []any{directors.Name, directors.Surname, jsonArray(&directors.Awords), directors.ID}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&directors.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SQLiteActorModel) INSERTACTOR(ctx context.Context, actor *Actor) error {
+	defer observeQuery("actors", "INSERTACTOR", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO actor(fullname, year, girlfriend, films)
+		VALUES (?1, ?2, ?3, ?4)
+		RETURNING id, created_at`
+	return m.DB.QueryRowContext(ctx, query, actor.Fullname, actor.Year, actor.Girlfriend, jsonArray(&actor.Films)).Scan(&actor.ID, &actor.CreatedAt)
+}
+
+func (m SQLiteMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	defer observeQuery("movies", "Insert", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO movies(title, year, runtime, genres, updated_at)
+		VALUES (?1, ?2, ?3, ?4, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at, version`
+	return m.DB.QueryRowContext(ctx, query, movie.Title, movie.Year, movie.Runtime, jsonArray(&movie.Genres)).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+}
+
+// InsertBatch inserts movies in a single transaction. The INSERTs are cheap once they
+// don't each need a transaction of their own. Like MovieModel.InsertBatch, it is only
+// bounded by ctx rather than QueryTimeout.
+func (m SQLiteMovieModel) InsertBatch(ctx context.Context, movies []*Movie) error {
+	defer observeQuery("movies", "InsertBatch", time.Now())
+
+	return inTx(ctx, m.DB, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO movies(title, year, runtime, genres, updated_at)
+			VALUES (?1, ?2, ?3, ?4, CURRENT_TIMESTAMP)
+			RETURNING id, created_at, updated_at, version`
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, movie := range movies {
+			err = stmt.QueryRowContext(ctx, movie.Title, movie.Year, movie.Runtime, jsonArray(&movie.Genres)).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m SQLiteMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	defer observeQuery("movies", "Get", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, updated_at, title, year, runtime, genres, version FROM movies
+WHERE id = ?1`
+	var movie Movie
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&movie.ID,
+		&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, jsonArray(&movie.Genres), &movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+func (m SQLiteActorModel) GetActors(ctx context.Context, id int64) (*Actor, error) {
+	defer observeQuery("actors", "GetActors", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, fullname, year, films, girlfriend FROM actor
+WHERE id = ?1`
+	var actor Actor
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&actor.ID,
+		&actor.CreatedAt, &actor.Fullname, &actor.Year, jsonArray(&actor.Films), &actor.Girlfriend,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &actor, nil
+}
+
+func (m SQLiteMovieModel) GetByTitle(ctx context.Context, title string) (*Movie, error) {
+	defer observeQuery("movies", "GetByTitle", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+SELECT id, created_at, updated_at, title, year, runtime, genres, version FROM movies
+WHERE title = ?1`
+	var movie Movie
+	err := m.DB.QueryRowContext(ctx, query, title).Scan(&movie.ID,
+		&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, jsonArray(&movie.Genres), &movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+func (m SQLiteActorModel) UpdateActor(ctx context.Context, actor *Actor) error {
+	defer observeQuery("actors", "UpdateActor", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+	UPDATE actor
+	SET fullname = ?1, year = ?2, films = ?3, girlfriend = ?4
+	WHERE id = ?5
+	RETURNING girlfriend`
+	args := []any{
+		actor.Fullname,
+		actor.Year,
+		jsonArray(&actor.Films),
+		actor.Girlfriend,
+		actor.ID,
+	}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&actor.Girlfriend)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SQLiteMovieModel) Update(ctx context.Context, movie *Movie) error {
+	defer observeQuery("movies", "Update", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+UPDATE movies
+SET title = ?1, year = ?2, runtime = ?3, genres = ?4, version = version + 1, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?5 AND version = ?6
+RETURNING version, updated_at`
+	args := []any{movie.Title,
+		movie.Year,
+		movie.Runtime,
+		jsonArray(&movie.Genres),
+		movie.ID,
+		movie.Version,
+	}
+	// As with PostgreSQL, no matching row means the version has changed (or the movie
+	// has been deleted) since it was read.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SQLiteActorModel) DeleteActor(ctx context.Context, id int64) error {
+	defer observeQuery("actors", "DeleteActor", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	return sqliteDelete(ctx, m.DB, `DELETE FROM actor WHERE id = ?1`, id)
+}
+
+func (m SQLiteMovieModel) Delete(ctx context.Context, id int64) error {
+	defer observeQuery("movies", "Delete", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	return sqliteDelete(ctx, m.DB, `DELETE FROM movies WHERE id = ?1`, id)
+}
+
+func (m SQLiteMovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, error) {
+	defer observeQuery("movies", "GetAll", time.Now())
+
+	// The title is matched against the FTS5 index, and the genres filter keeps the
+	// movies whose genres array contains every requested genre (like @> does).
+	query := fmt.Sprintf(`
+SELECT id, created_at, updated_at, title, year, runtime, genres, version
+FROM movies
+WHERE (?1 = '' OR id IN (SELECT rowid FROM movies_fts WHERE movies_fts MATCH ?1))
+AND NOT EXISTS (
+	SELECT 1 FROM json_each(?2) AS wanted
+	WHERE wanted.value NOT IN (SELECT value FROM json_each(movies.genres))
+)
+ORDER BY %s %s, id ASC
+LIMIT ?3 OFFSET ?4`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	args := []any{ftsQuery(title), jsonArray(&genres), filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(&movie.ID,
+			&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, jsonArray(&movie.Genres), &movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// Export calls fn with every movie matching the filters, like MovieModel.Export. SQLite
+// has no cursors to declare, but the driver steps through the result set one row at a
+// time as it is read, which amounts to the same. The query isn't bounded by the query
+// timeout, as it lasts as long as the client needs to download the export.
+func (m SQLiteMovieModel) Export(ctx context.Context, title string, genres []string, filters Filters, fn func(movie *Movie) error) error {
+	defer observeQuery("movies", "Export", time.Now())
+
+	query := fmt.Sprintf(`
+SELECT id, created_at, updated_at, title, year, runtime, genres, version
+FROM movies
+WHERE (?1 = '' OR id IN (SELECT rowid FROM movies_fts WHERE movies_fts MATCH ?1))
+AND NOT EXISTS (
+	SELECT 1 FROM json_each(?2) AS wanted
+	WHERE wanted.value NOT IN (SELECT value FROM json_each(movies.genres))
+)
+ORDER BY %s %s, id ASC`, filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, ftsQuery(title), jsonArray(&genres))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(&movie.ID,
+			&movie.CreatedAt, &movie.UpdatedAt, &movie.Title, &movie.Year, &movie.Runtime, jsonArray(&movie.Genres), &movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (m SQLiteDirectorModel) GetAllDirectors(ctx context.Context, name string, surname string, awords []string, filters Filters) ([]*Directors, error) {
+	defer observeQuery("directors", "GetAllDirectors", time.Now())
+
+	query := fmt.Sprintf(`
+SELECT id, name, surname, awords
+FROM directors
+WHERE (?1 = '' OR id IN (SELECT rowid FROM directors_fts WHERE directors_fts MATCH ?1))
+AND NOT EXISTS (
+	SELECT 1 FROM json_each(?2) AS wanted
+	WHERE wanted.value NOT IN (SELECT value FROM json_each(directors.awords))
+)
+ORDER BY %s %s, id ASC
+LIMIT ?3 OFFSET ?4`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	args := []any{ftsQuery(name), jsonArray(&awords), filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	director := []*Directors{}
+	for rows.Next() {
+		var directors Directors
+		err := rows.Scan(&directors.ID,
+			&directors.Name, &directors.Surname, jsonArray(&directors.Awords),
+		)
+		if err != nil {
+			return nil, err
+		}
+		director = append(director, &directors)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return director, nil
+}
+
+// sqliteDelete runs a DELETE statement and returns ErrRecordNotFound if it didn't
+// affect any row.
+func sqliteDelete(ctx context.Context, db DBTX, query string, args ...any) error {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ftsQuery turns a free-text search into an FTS5 query matching rows which contain
+// every word, like plainto_tsquery() does. Each word is quoted, so that characters
+// with a meaning in the FTS5 query syntax are treated as plain text.
+func ftsQuery(s string) string {
+	words := textSearchWords(s)
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// jsonArray returns an adapter for storing a []string in a JSON text column, playing
+// the role pq.Array() plays for PostgreSQL arrays.
+func jsonArray(a *[]string) interface {
+	driver.Valuer
+	sql.Scanner
+} {
+	return (*stringArray)(a)
+}
+
+type stringArray []string
+
+// Value implements the driver.Valuer interface. A nil slice is stored as an empty
+// array, since the array columns are NOT NULL.
+func (a *stringArray) Value() (driver.Value, error) {
+	if *a == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(*a))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (a *stringArray) Scan(src any) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), (*[]string)(a))
+	case []byte:
+		return json.Unmarshal(src, (*[]string)(a))
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON string array", src)
+	}
+}
+
+// SQLiteIdempotencyModel stores the idempotency keys in SQLite. It behaves like
+// IdempotencyModel; the expiry times are UTC times in the format of datetime('now').
+type SQLiteIdempotencyModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+func (m SQLiteIdempotencyModel) Reserve(ctx context.Context, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	defer observeQuery("idempotency_keys", "Reserve", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	query := `
+INSERT INTO idempotency_keys (key, request_hash, expires_at)
+VALUES (?1, ?2, datetime('now', ?3))
+ON CONFLICT (key) DO UPDATE
+SET request_hash = excluded.request_hash, status = 0, header = '{}', body = NULL,
+	created_at = CURRENT_TIMESTAMP, expires_at = excluded.expires_at
+WHERE idempotency_keys.expires_at <= datetime('now')
+RETURNING key`
+	expiry := fmt.Sprintf("+%d seconds", int64(ttl/time.Second))
+	for {
+		var reserved string
+		err := m.DB.QueryRowContext(ctx, query, key, requestHash, expiry).Scan(&reserved)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		record, err := m.get(ctx, key)
+		if !errors.Is(err, ErrRecordNotFound) {
+			return record, err
+		}
+	}
+}
+
+func (m SQLiteIdempotencyModel) get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	query := `
+SELECT request_hash, status, header, body FROM idempotency_keys
+WHERE key = ?1 AND expires_at > datetime('now')`
+	var record IdempotencyRecord
+	var header string
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&record.RequestHash, &record.Status, &header, &record.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &record, json.Unmarshal([]byte(header), &record.Header)
+}
+
+func (m SQLiteIdempotencyModel) Complete(ctx context.Context, key string, status int, header map[string][]string, body []byte) error {
+	defer observeQuery("idempotency_keys", "Complete", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	js, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	query := `
+UPDATE idempotency_keys SET status = ?2, header = ?3, body = ?4
+WHERE key = ?1`
+	_, err = m.DB.ExecContext(ctx, query, key, status, string(js), body)
+	return err
+}
+
+func (m SQLiteIdempotencyModel) Release(ctx context.Context, key string) error {
+	defer observeQuery("idempotency_keys", "Release", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = ?1 AND status = 0`, key)
+	return err
+}
+
+func (m SQLiteIdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	defer observeQuery("idempotency_keys", "DeleteExpired", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= datetime('now')`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
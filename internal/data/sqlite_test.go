@@ -0,0 +1,381 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"github.com/shynggys9219/greenlight/migrations"
+	_ "modernc.org/sqlite"
+)
+
+// newTestSQLiteDB opens an in-memory SQLite database and applies the embedded SQLite
+// migrations to it.
+func newTestSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to file::memory: gets its own database, so make sure all the
+	// queries go through the same one.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	goose.SetBaseFS(migrations.FS)
+	goose.SetLogger(goose.NopLogger())
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Up(db, migrations.Dir("sqlite")); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLiteMovieModel(t *testing.T) {
+	ctx := context.Background()
+	m := SQLiteMovieModel{DB: newTestSQLiteDB(t), QueryTimeout: time.Second}
+
+	for _, movie := range []*Movie{
+		{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action", "adventure"}},
+		{Title: "The Breakfast Club", Year: 1986, Runtime: 96, Genres: []string{"drama"}},
+	} {
+		if err := m.Insert(ctx, movie); err != nil {
+			t.Fatal(err)
+		}
+		if movie.ID == 0 || movie.Version != 1 || movie.CreatedAt.IsZero() {
+			t.Fatalf("insert didn't fill in the generated fields: %+v", movie)
+		}
+	}
+
+	// The check constraints are enforced by triggers.
+	if err := m.Insert(ctx, &Movie{Title: "Moana", Year: 2016, Runtime: -1, Genres: []string{"animation"}}); err == nil {
+		t.Error("insert with a negative runtime: got no error")
+	}
+
+	movie, err := m.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"animation", "adventure"}; !reflect.DeepEqual(movie.Genres, want) {
+		t.Errorf("got genres %q; want %q", movie.Genres, want)
+	}
+
+	// Renaming the movie must keep the FTS index in sync.
+	movie.Title = "Moana Club"
+	if err := m.Update(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+	movie.Version = 1
+	if err := m.Update(ctx, movie); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("update with a stale version: got error %v; want %v", err, ErrEditConflict)
+	}
+
+	tests := []struct {
+		name       string
+		title      string
+		genres     []string
+		sort       string
+		wantTitles []string
+	}{
+		{"All", "", []string{}, "id", []string{"Moana Club", "Black Panther", "The Breakfast Club"}},
+		{"Title search", "club", []string{}, "id", []string{"Moana Club", "The Breakfast Club"}},
+		{"Title with FTS syntax", `club" OR "moana`, []string{}, "id", []string{}},
+		{"Genres", "", []string{"adventure"}, "-year", []string{"Black Panther", "Moana Club"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafelist: []string{tt.sort}}
+			movies, err := m.GetAll(ctx, tt.title, tt.genres, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			titles := []string{}
+			for _, movie := range movies {
+				titles = append(titles, movie.Title)
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Errorf("got titles %q; want %q", titles, tt.wantTitles)
+			}
+		})
+	}
+
+	if err := m.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(ctx, 1); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("second delete: got error %v; want %v", err, ErrRecordNotFound)
+	}
+	if _, err := m.Get(ctx, 1); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("get after delete: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestSQLiteSchemaVersion(t *testing.T) {
+	m := HealthModel{DB: newTestSQLiteDB(t)}
+
+	version, err := m.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != RequiredSchemaVersion {
+		t.Errorf("got schema version %d; want %d", version, RequiredSchemaVersion)
+	}
+}
+
+func TestSQLiteActorModel(t *testing.T) {
+	ctx := context.Background()
+	m := SQLiteActorModel{DB: newTestSQLiteDB(t), QueryTimeout: time.Second}
+
+	actor := &Actor{Fullname: "Dwayne Johnson", Year: 1972, Films: []string{"Moana"}, Girlfriend: "Lauren"}
+	if err := m.INSERTACTOR(ctx, actor); err != nil {
+		t.Fatal(err)
+	}
+
+	actor.Films = append(actor.Films, "Jumanji")
+	if err := m.UpdateActor(ctx, actor); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.GetActors(ctx, actor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Moana", "Jumanji"}; !reflect.DeepEqual(got.Films, want) {
+		t.Errorf("got films %q; want %q", got.Films, want)
+	}
+
+	if err := m.DeleteActor(ctx, actor.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetActors(ctx, actor.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("get after delete: got error %v; want %v", err, ErrRecordNotFound)
+	}
+	if err := m.UpdateActor(ctx, actor); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("update after delete: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestSQLiteDirectorModel(t *testing.T) {
+	ctx := context.Background()
+	m := SQLiteDirectorModel{DB: newTestSQLiteDB(t), QueryTimeout: time.Second}
+
+	director := &Directors{Name: "Ron", Surname: "Clements"}
+	if err := m.InsertDirector(ctx, director); err != nil {
+		t.Fatal(err)
+	}
+
+	director.Name = "Ronald"
+	director.Awords = []string{"Annie Award"}
+	if err := m.UpdateDirector(ctx, director); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.GetDirector(ctx, director.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, director) {
+		t.Errorf("got %+v; want %+v", got, director)
+	}
+
+	// The full-text index follows the renamed director.
+	found, err := m.GetAllDirectors(ctx, "Ronald", "", nil, Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Errorf("got %d directors named Ronald; want 1", len(found))
+	}
+
+	if err := m.UpdateDirector(ctx, &Directors{ID: 42, Name: "Up"}); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("update missing director: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestSQLiteInsertBatch(t *testing.T) {
+	ctx := context.Background()
+	m := SQLiteMovieModel{DB: newTestSQLiteDB(t), QueryTimeout: time.Second}
+
+	movies := []*Movie{
+		{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}},
+		{Title: "Up", Year: 2009, Runtime: 96, Genres: []string{"animation"}},
+	}
+	if err := m.InsertBatch(ctx, movies); err != nil {
+		t.Fatal(err)
+	}
+	for i, movie := range movies {
+		if movie.ID != int64(i+1) || movie.Version != 1 {
+			t.Errorf("movie %d: got ID %d and version %d", i, movie.ID, movie.Version)
+		}
+	}
+
+	// A batch is inserted in full or not at all.
+	err := m.InsertBatch(ctx, []*Movie{
+		{Title: "Coco", Year: 2017, Runtime: 105, Genres: []string{"animation"}},
+		{Title: "Invalid", Year: 1000, Runtime: 1, Genres: []string{"drama"}},
+	})
+	if err == nil {
+		t.Fatal("got no error for a movie breaking a check constraint")
+	}
+	if _, err := m.GetByTitle(ctx, "Coco"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v for a movie of the failed batch; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestSQLiteExport(t *testing.T) {
+	ctx := context.Background()
+	m := SQLiteMovieModel{DB: newTestSQLiteDB(t), QueryTimeout: time.Second}
+
+	err := m.InsertBatch(ctx, []*Movie{
+		{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "musical"}},
+		{Title: "Up", Year: 2009, Runtime: 96, Genres: []string{"animation"}},
+		{Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"crime"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	filters := Filters{Sort: "-year", SortSafelist: []string{"-year"}}
+	err = m.Export(ctx, "", []string{"animation"}, filters, func(movie *Movie) error {
+		titles = append(titles, movie.Title)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Moana", "Up"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("got %q; want %q", titles, want)
+	}
+
+	// An error returned by fn stops the export.
+	errStop := errors.New("stop")
+	calls := 0
+	err = m.Export(ctx, "", nil, filters, func(movie *Movie) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("got error %v after %d calls; want %v after 1", err, calls, errStop)
+	}
+}
+
+func TestSQLiteModelsWithTx(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+	models := NewSQLiteModels(db, time.Second)
+	errRollback := errors.New("rollback")
+
+	err := models.WithTx(ctx, func(tx Models) error {
+		if err := tx.Movies.Insert(ctx, &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got error %v; want %v", err, errRollback)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic wasn't propagated")
+			}
+		}()
+		_ = models.WithTx(ctx, func(tx Models) error {
+			_ = tx.Actor.INSERTACTOR(ctx, &Actor{Fullname: "Dwayne Johnson", Year: 1972})
+			panic("boom")
+		})
+	}()
+
+	err = models.WithTx(ctx, func(tx Models) error {
+		if err := tx.Movies.Insert(ctx, &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}); err != nil {
+			return err
+		}
+		// Nested calls run in the same transaction.
+		return tx.WithTx(ctx, func(tx Models) error {
+			return tx.Actor.INSERTACTOR(ctx, &Actor{Fullname: "Dwayne Johnson", Year: 1972})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the records from the committed transaction are there.
+	for _, table := range []string{"movies", "actor"} {
+		var count int
+		if err := db.QueryRowContext(ctx, `SELECT count(*) FROM `+table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("got %d rows in %s; want 1", count, table)
+		}
+	}
+}
+
+func TestSQLiteIdempotencyModel(t *testing.T) {
+	ctx := context.Background()
+	m := SQLiteIdempotencyModel{DB: newTestSQLiteDB(t), QueryTimeout: time.Second}
+
+	record, err := m.Reserve(ctx, "key", "hash", time.Hour)
+	if err != nil || record != nil {
+		t.Fatalf("got %+v, %v for a new key; want nil, nil", record, err)
+	}
+
+	// The key is in use until the request is complete.
+	record, err = m.Reserve(ctx, "key", "other", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil || record.RequestHash != "hash" || record.Status != 0 {
+		t.Fatalf("got %+v for a key in use", record)
+	}
+
+	header := map[string][]string{"Location": {"/v1/movies/1"}}
+	if err := m.Complete(ctx, "key", 201, header, []byte(`{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Release() leaves the completed keys alone.
+	if err := m.Release(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	record, err = m.Reserve(ctx, "key", "hash", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &IdempotencyRecord{RequestHash: "hash", Status: 201, Header: header, Body: []byte(`{"id":1}`)}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("got %+v; want %+v", record, want)
+	}
+
+	// A released key can be reserved again.
+	if _, err := m.Reserve(ctx, "released", "hash", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Release(ctx, "released"); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := m.Reserve(ctx, "released", "other", time.Hour); err != nil || record != nil {
+		t.Errorf("got %+v, %v for a released key; want nil, nil", record, err)
+	}
+
+	// So can an expired one, which DeleteExpired() deletes.
+	if _, err := m.Reserve(ctx, "expired", "hash", 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := m.DeleteExpired(ctx)
+	if err != nil || n != 1 {
+		t.Errorf("got %d, %v from DeleteExpired(); want 1, nil", n, err)
+	}
+	if record, err := m.Reserve(ctx, "expired", "other", 0); err != nil || record != nil {
+		t.Errorf("got %+v, %v for an expired key; want nil, nil", record, err)
+	}
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTxAttempts is the number of times WithTx runs a transaction which keeps failing
+// with a serialization error before giving up.
+const maxTxAttempts = 3
+
+// DBTX is the subset of the methods shared by *sql.DB and *sql.Tx that the models use,
+// so that the same model code can run either directly on the connection pool or inside
+// a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// txRunner runs fn inside a single transaction, passing it a copy of the models bound
+// to that transaction.
+type txRunner func(ctx context.Context, fn func(tx Models) error) error
+
+// WithTx runs fn with a copy of the models whose queries all go through the same
+// transaction. The transaction is committed if fn returns nil, and rolled back if fn
+// returns an error or panics (in which case the panic is propagated once the rollback
+// is done).
+//
+// Transactions which fail because they conflict with a concurrent one (serialization
+// failures or deadlocks in PostgreSQL, a busy database in SQLite) are retried from the
+// start, up to maxTxAttempts times in total, so fn must be safe to run more than once.
+// Calling WithTx on models which are already bound to a transaction runs fn in that
+// same transaction.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.runTx == nil {
+		return errors.New("data: transactions are not supported by these models")
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = m.runTx(ctx, fn)
+		if !isSerializationFailure(err) {
+			return err
+		}
+
+		// Back off a little before retrying, so that the conflicting transaction has a
+		// chance to finish.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("transaction failed after %d attempts: %w", maxTxAttempts, err)
+}
+
+// sqlTxRunner returns a txRunner which runs each transaction on db with the given
+// options. The bind function returns the models bound to the transaction.
+func sqlTxRunner(db *sql.DB, opts *sql.TxOptions, bind func(tx DBTX) Models) txRunner {
+	return func(ctx context.Context, fn func(tx Models) error) (err error) {
+		tx, err := db.BeginTx(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		// Roll the transaction back if fn panics or returns an error. A panic is
+		// re-raised once the rollback is done.
+		defer func() {
+			if p := recover(); p != nil {
+				_ = tx.Rollback()
+				panic(p)
+			}
+			if err != nil {
+				_ = tx.Rollback()
+			}
+		}()
+
+		models := bind(tx)
+		// Nested calls to WithTx run in the transaction that is already open.
+		models.runTx = func(ctx context.Context, fn func(tx Models) error) error {
+			return fn(models)
+		}
+
+		err = fn(models)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+}
+
+// inTx runs fn in the transaction db is bound to, if it is one, or else in a new
+// transaction on db which is committed if fn returns nil. It is used by the model
+// methods which need a transaction of their own, such as InsertBatch.
+func inTx(ctx context.Context, db DBTX, fn func(tx *sql.Tx) error) error {
+	switch db := db.(type) {
+	case *sql.Tx:
+		return fn(db)
+	case *sql.DB:
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// Rolling back a committed transaction is a no-op.
+		defer tx.Rollback()
+
+		err = fn(tx)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	default:
+		return fmt.Errorf("data: unable to start a transaction on %T", db)
+	}
+}
+
+// ErrSerializationFailure is returned by the in-memory models when a transaction
+// conflicts with a write made since it started.
+var ErrSerializationFailure = errors.New("could not serialize access due to concurrent update")
+
+// isSerializationFailure reports whether err means that the transaction conflicted with
+// a concurrent one and can be retried.
+func isSerializationFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrSerializationFailure) {
+		return true
+	}
+
+	// PostgreSQL: serialization_failure and deadlock_detected.
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+
+	// SQLite: SQLITE_BUSY and SQLITE_LOCKED, including their extended codes. The
+	// error is matched on its Code() method so that this package doesn't have to
+	// import the driver.
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == 5 || code == 6
+	}
+	return false
+}
@@ -1 +0,0 @@
-DROP TABLE IF EXISTS movies;
\ No newline at end of file
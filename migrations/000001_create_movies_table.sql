@@ -1,3 +1,4 @@
+-- +goose Up
 -- null values are not appreciated in GoLang 
 -- So all columns either not null or have default vals
 CREATE TABLE IF NOT EXISTS movies (
@@ -12,3 +13,5 @@ CREATE TABLE IF NOT EXISTS movies (
     version integer NOT NULL DEFAULT 1
 );
 
+-- +goose Down
+DROP TABLE IF EXISTS movies;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- Each constraint is dropped first, so that the migration also applies cleanly to
+-- databases which were migrated before goose was introduced.
+
+-- make sure that the runtime value is always greater than zero
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_runtime_check;
+ALTER TABLE movies 
+        ADD CONSTRAINT movies_runtime_check 
+        CHECK (runtime>=0);
+        
+-- year value is between 1888 and the current year
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+ALTER TABLE movies 
+        ADD CONSTRAINT movies_year_check 
+        CHECK (year BETWEEN 1888 AND date_part('year',now()));
+
+-- genres array always contains between 1 and 5 items.
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS genres_length_check;
+ALTER TABLE movies 
+        ADD CONSTRAINT genres_length_check 
+        CHECK (array_length(genres, 1) BETWEEN 1 AND 5);
+
+-- +goose Down
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_runtime_check;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS genres_length_check;
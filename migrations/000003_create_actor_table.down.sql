@@ -1 +0,0 @@
-DROP TABLE IF EXISTS actor;
\ No newline at end of file
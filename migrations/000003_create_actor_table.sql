@@ -1,3 +1,4 @@
+-- +goose Up
 CREATE TABLE IF NOT EXISTS actor (
     -- id column is a 64-bit auto-incrementing integer & primary key (defines the row)
     id bigserial PRIMARY KEY,
@@ -6,4 +7,7 @@ CREATE TABLE IF NOT EXISTS actor (
     year integer not null,
     films text[] not NULL,
     girlfriend text
-    );
\ No newline at end of file
+    );
+
+-- +goose Down
+DROP TABLE IF EXISTS actor;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS directors (
+    -- id column is a 64-bit auto-incrementing integer & primary key (defines the row)
+    id bigserial PRIMARY KEY,
+    name text not null,
+    surname text not null,
+    -- awards received by the director, zero-or-more text values
+    awords text[] not NULL DEFAULT '{}'
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS directors;
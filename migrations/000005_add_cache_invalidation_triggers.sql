@@ -0,0 +1,28 @@
+-- +goose Up
+-- Every instance of the API keeps a local cache of the movies and actors, so notify
+-- them whenever one of those rows changes, whichever instance (or psql session) made
+-- the change. The payload is "<table>:<id>", e.g. "movies:42". Notifications sent
+-- inside a transaction are only delivered once it commits.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_cache_invalidation() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('greenlight_cache', TG_TABLE_NAME || ':' || OLD.id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS movies_notify_cache ON movies;
+CREATE TRIGGER movies_notify_cache
+    AFTER UPDATE OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation();
+
+DROP TRIGGER IF EXISTS actor_notify_cache ON actor;
+CREATE TRIGGER actor_notify_cache
+    AFTER UPDATE OR DELETE ON actor
+    FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS actor_notify_cache ON actor;
+DROP TRIGGER IF EXISTS movies_notify_cache ON movies;
+DROP FUNCTION IF EXISTS notify_cache_invalidation();
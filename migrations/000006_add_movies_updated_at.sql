@@ -0,0 +1,13 @@
+-- +goose Up
+-- updated_at is set by MovieModel.Update and is sent to the clients as the
+-- Last-Modified header. Existing movies are considered last modified when created.
+-- The backfill only sets the column added here, which no running instance has cached
+-- yet, so the cache invalidation trigger (000005) is disabled around it rather than
+-- sending one notification per movie.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE movies DISABLE TRIGGER movies_notify_cache;
+UPDATE movies SET updated_at = created_at;
+ALTER TABLE movies ENABLE TRIGGER movies_notify_cache;
+
+-- +goose Down
+ALTER TABLE movies DROP COLUMN IF EXISTS updated_at;
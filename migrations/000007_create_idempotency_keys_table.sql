@@ -0,0 +1,19 @@
+-- +goose Up
+-- The Idempotency-Key of each create request, with the response sent to it, so that
+-- a retried request gets the same response instead of creating a duplicate.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key text PRIMARY KEY,
+    -- SHA-256 of the method, path and body of the request which used the key
+    request_hash text NOT NULL,
+    -- 0 while the request is being processed
+    status integer NOT NULL DEFAULT 0,
+    header jsonb NOT NULL DEFAULT '{}',
+    body bytea,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS idempotency_keys;
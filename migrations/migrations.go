@@ -0,0 +1,19 @@
+// Package migrations embeds the SQL migrations, so that the api binary can apply them
+// without the migration files being deployed alongside it. The files at the top level
+// are for PostgreSQL and the ones in the sqlite directory for SQLite. Both sets use the
+// goose format, with the up and down steps in the same file.
+package migrations
+
+import "embed"
+
+//go:embed *.sql sqlite/*.sql
+var FS embed.FS
+
+// Dir returns the directory of FS holding the migrations for the given database
+// driver.
+func Dir(driver string) string {
+	if driver == "sqlite" {
+		return "sqlite"
+	}
+	return "."
+}
@@ -0,0 +1,46 @@
+-- +goose Up
+-- SQLite version of the movies table. SQLite has no array type, so genres is stored
+-- as a JSON array of strings.
+CREATE TABLE IF NOT EXISTS movies (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text NOT NULL DEFAULT '[]' CHECK (json_valid(genres)),
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Full-text index on the title, used in place of PostgreSQL's to_tsvector(). It is an
+-- external content table, kept in sync with movies by the triggers below.
+CREATE VIRTUAL TABLE IF NOT EXISTS movies_fts USING fts5(
+    title,
+    content = 'movies',
+    content_rowid = 'id'
+);
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS movies_fts_insert AFTER INSERT ON movies BEGIN
+    INSERT INTO movies_fts(rowid, title) VALUES (new.id, new.title);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS movies_fts_delete AFTER DELETE ON movies BEGIN
+    INSERT INTO movies_fts(movies_fts, rowid, title) VALUES ('delete', old.id, old.title);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS movies_fts_update AFTER UPDATE OF title ON movies BEGIN
+    INSERT INTO movies_fts(movies_fts, rowid, title) VALUES ('delete', old.id, old.title);
+    INSERT INTO movies_fts(rowid, title) VALUES (new.id, new.title);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS movies_fts_update;
+DROP TRIGGER IF EXISTS movies_fts_delete;
+DROP TRIGGER IF EXISTS movies_fts_insert;
+DROP TABLE IF EXISTS movies_fts;
+DROP TABLE IF EXISTS movies;
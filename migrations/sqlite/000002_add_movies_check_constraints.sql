@@ -0,0 +1,26 @@
+-- +goose Up
+-- SQLite can't add constraints to an existing table, and doesn't allow date('now') in
+-- CHECK constraints, so the PostgreSQL constraints are enforced with triggers instead.
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS movies_check_insert BEFORE INSERT ON movies BEGIN
+    SELECT RAISE(ABORT, 'movies_runtime_check') WHERE new.runtime < 0;
+    SELECT RAISE(ABORT, 'movies_year_check')
+        WHERE new.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer);
+    SELECT RAISE(ABORT, 'genres_length_check')
+        WHERE json_array_length(new.genres) NOT BETWEEN 1 AND 5;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS movies_check_update BEFORE UPDATE ON movies BEGIN
+    SELECT RAISE(ABORT, 'movies_runtime_check') WHERE new.runtime < 0;
+    SELECT RAISE(ABORT, 'movies_year_check')
+        WHERE new.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer);
+    SELECT RAISE(ABORT, 'genres_length_check')
+        WHERE json_array_length(new.genres) NOT BETWEEN 1 AND 5;
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS movies_check_update;
+DROP TRIGGER IF EXISTS movies_check_insert;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS actor (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    fullname text NOT NULL,
+    year integer NOT NULL,
+    -- JSON array of strings, in place of PostgreSQL's text[]
+    films text NOT NULL DEFAULT '[]' CHECK (json_valid(films)),
+    girlfriend text
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS actor;
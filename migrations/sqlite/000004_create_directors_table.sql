@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS directors (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text NOT NULL,
+    surname text NOT NULL,
+    -- JSON array of strings, in place of PostgreSQL's text[]
+    awords text NOT NULL DEFAULT '[]' CHECK (json_valid(awords))
+);
+
+-- Full-text index on the name, kept in sync with directors by the triggers below.
+CREATE VIRTUAL TABLE IF NOT EXISTS directors_fts USING fts5(
+    name,
+    content = 'directors',
+    content_rowid = 'id'
+);
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS directors_fts_insert AFTER INSERT ON directors BEGIN
+    INSERT INTO directors_fts(rowid, name) VALUES (new.id, new.name);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS directors_fts_delete AFTER DELETE ON directors BEGIN
+    INSERT INTO directors_fts(directors_fts, rowid, name) VALUES ('delete', old.id, old.name);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS directors_fts_update AFTER UPDATE OF name ON directors BEGIN
+    INSERT INTO directors_fts(directors_fts, rowid, name) VALUES ('delete', old.id, old.name);
+    INSERT INTO directors_fts(rowid, name) VALUES (new.id, new.name);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS directors_fts_update;
+DROP TRIGGER IF EXISTS directors_fts_delete;
+DROP TRIGGER IF EXISTS directors_fts_insert;
+DROP TABLE IF EXISTS directors_fts;
+DROP TABLE IF EXISTS directors;
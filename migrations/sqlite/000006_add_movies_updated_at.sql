@@ -0,0 +1,8 @@
+-- +goose Up
+-- SQLite doesn't allow CURRENT_TIMESTAMP as the default of an added column, so the
+-- movie models set updated_at themselves on insert and update.
+ALTER TABLE movies ADD COLUMN updated_at timestamp NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE movies SET updated_at = created_at;
+
+-- +goose Down
+ALTER TABLE movies DROP COLUMN updated_at;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key text PRIMARY KEY,
+    request_hash text NOT NULL,
+    status integer NOT NULL DEFAULT 0,
+    -- JSON object, in place of PostgreSQL's jsonb
+    header text NOT NULL DEFAULT '{}' CHECK (json_valid(header)),
+    body blob,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Compared with datetime('now'), so it holds UTC times in the same format.
+    expires_at text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS idempotency_keys;